@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -20,74 +21,198 @@ type AEAD interface {
 	Decrypt(ciphertext, associatedData []byte) ([]byte, error)
 }
 
-// aesGCMAEAD is a implementation of the AEAD interface cookies are secured
-// with, that uses AES-GCM with a random nonce. A single key should not be used
-// for more than 4 billion encryptions. It is reccomended that tink with an
-// automated key rotation is used, this is provided for simple use cases.
-type aesGCMAEAD struct {
-	encryptionKey  []byte
-	decryptionKeys [][]byte
+// RotatingAEAD can optionally be implemented by an AEAD that supports key
+// rotation. When implemented, stores can detect data that was encrypted with a
+// key other than the current primary and transparently re-encrypt it.
+type RotatingAEAD interface {
+	AEAD
+	// EncryptedWithPrimary returns true if the ciphertext was encrypted with
+	// the current primary key.
+	EncryptedWithPrimary(ciphertext []byte) bool
 }
 
-// newAESGCMAEAD constructs an AESGCMAEAD. The keys must be either 16, 24 or 32
-// bytes. The encryption key is used as the primary encrypt/decrypt key.
-// Additional decryption-only keys can be provided, to enable key rotation.
-func newAESGCMAEAD(encryptionKey []byte, additionalDecryptionKeys [][]byte) (AEAD, error) {
-	for _, k := range append([][]byte{encryptionKey}, additionalDecryptionKeys...) {
-		if len(k) != 16 && len(k) != 24 && len(k) != 32 {
-			return nil, errors.New("keys must be 16, 24, or 32 bytes")
-		}
+// KeyState indicates how a key in an AESGCMKeyset can be used.
+type KeyState int
+
+const (
+	// KeyStatePrimary marks the key used to encrypt new data. It can also be
+	// used to decrypt. There must be exactly one primary key in a keyset.
+	KeyStatePrimary KeyState = iota + 1
+	// KeyStateDecryptOnly marks a key that is only used to decrypt existing
+	// data. Keys should be moved to this state when a new primary is
+	// introduced, and kept until all data encrypted with them has expired.
+	KeyStateDecryptOnly
+	// KeyStateRetired marks a key that is no longer used. Data encrypted with
+	// it will fail to decrypt with ErrKeyRetired.
+	KeyStateRetired
+)
+
+func (k KeyState) String() string {
+	switch k {
+	case KeyStatePrimary:
+		return "primary"
+	case KeyStateDecryptOnly:
+		return "decrypt-only"
+	case KeyStateRetired:
+		return "retired"
+	default:
+		return fmt.Sprintf("KeyState(%d)", int(k))
 	}
+}
 
-	return &aesGCMAEAD{
-		encryptionKey:  encryptionKey,
-		decryptionKeys: additionalDecryptionKeys,
-	}, nil
+// AESGCMKey is a single key in an AESGCMKeyset.
+type AESGCMKey struct {
+	// ID identifies the key. It is written in to the header of the
+	// ciphertext, so it must be unique within the keyset and never re-used
+	// for different key material.
+	ID uint32
+	// Key is the AES key, it must be 16, 24 or 32 bytes.
+	Key []byte
+	// State indicates how the key can be used.
+	State KeyState
 }
 
-func (a *aesGCMAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
-	block, err := aes.NewCipher(a.encryptionKey)
-	if err != nil {
-		return nil, fmt.Errorf("creating AES cipher: %w", err)
-	}
+var (
+	// ErrKeyNotFound is returned when the ciphertext references a key ID that
+	// is not in the keyset.
+	ErrKeyNotFound = errors.New("key not found in keyset")
+	// ErrKeyRetired is returned when the ciphertext references a key that is
+	// retired.
+	ErrKeyRetired = errors.New("key is retired")
+)
 
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("creating GCM cipher: %w", err)
-	}
+const (
+	// aesGCMKeysetVersion is the first byte of the ciphertext, to allow the
+	// format to change in future.
+	aesGCMKeysetVersion = 0x01
+	// aesGCMHeaderLen is the version byte, plus the uint32 key ID.
+	aesGCMHeaderLen = 1 + 4
+	aesGCMNonceLen  = 12
+)
 
-	nonce := make([]byte, 12)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		panic(err)
-	}
-	return append(nonce, aesgcm.Seal(nil, nonce, plaintext, associatedData)...), nil
+var _ RotatingAEAD = (*AESGCMKeyset)(nil)
+
+// AESGCMKeyset is an implementation of the AEAD interface cookies are secured
+// with, that uses AES-GCM with a random nonce. Ciphertexts are prefixed with
+// the ID of the key that encrypted them, so decryption goes directly to the
+// correct key. This enables cheap key rotation, by adding a new primary key and
+// demoting the old one to decrypt-only.
+//
+// A single key should not be used for more than 4 billion encryptions. It is
+// reccomended that tink with an automated key rotation is used, this is
+// provided for simple use cases.
+type AESGCMKeyset struct {
+	primaryID uint32
+	keys      map[uint32]*aesGCMKey
 }
 
-func (a *aesGCMAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
-	if len(ciphertext) < 12 {
-		return nil, errors.New("invalid ciphertext")
+type aesGCMKey struct {
+	state KeyState
+	aead  cipher.AEAD
+}
+
+// NewAESGCMKeyset constructs an AESGCMKeyset from the given keys. Exactly one
+// key must be in the primary state.
+func NewAESGCMKeyset(keys []AESGCMKey) (*AESGCMKeyset, error) {
+	ks := &AESGCMKeyset{
+		keys: make(map[uint32]*aesGCMKey, len(keys)),
 	}
-	var plaintext []byte
-	for _, dk := range append([][]byte{a.encryptionKey}, a.decryptionKeys...) {
-		block, err := aes.NewCipher(dk)
-		if err != nil {
-			return nil, fmt.Errorf("creating AES cipher: %w", err)
+
+	var havePrimary bool
+	for _, k := range keys {
+		if _, ok := ks.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %d", k.ID)
+		}
+		if len(k.Key) != 16 && len(k.Key) != 24 && len(k.Key) != 32 {
+			return nil, fmt.Errorf("key %d: keys must be 16, 24, or 32 bytes", k.ID)
+		}
+
+		switch k.State {
+		case KeyStatePrimary:
+			if havePrimary {
+				return nil, fmt.Errorf("key %d: keyset can only have one primary key", k.ID)
+			}
+			havePrimary = true
+			ks.primaryID = k.ID
+		case KeyStateDecryptOnly, KeyStateRetired:
+		default:
+			return nil, fmt.Errorf("key %d: invalid state %s", k.ID, k.State)
 		}
 
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: creating AES cipher: %w", k.ID, err)
+		}
 		aesgcm, err := cipher.NewGCM(block)
 		if err != nil {
-			return nil, fmt.Errorf("creating GCM cipher: %w", err)
+			return nil, fmt.Errorf("key %d: creating GCM cipher: %w", k.ID, err)
 		}
 
-		pt, err := aesgcm.Open(nil, ciphertext[:12], ciphertext[12:], associatedData)
-		if err != nil {
-			continue
+		ks.keys[k.ID] = &aesGCMKey{
+			state: k.State,
+			aead:  aesgcm,
 		}
+	}
+
+	if !havePrimary {
+		return nil, errors.New("keyset must have a primary key")
+	}
+
+	return ks, nil
+}
+
+func (a *AESGCMKeyset) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	k := a.keys[a.primaryID]
+
+	out := make([]byte, aesGCMHeaderLen+aesGCMNonceLen, aesGCMHeaderLen+aesGCMNonceLen+len(plaintext)+k.aead.Overhead())
+	out[0] = aesGCMKeysetVersion
+	binary.BigEndian.PutUint32(out[1:aesGCMHeaderLen], a.primaryID)
+
+	nonce := out[aesGCMHeaderLen:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic(err)
+	}
+
+	return k.aead.Seal(out, nonce, plaintext, a.headerAD(out[:aesGCMHeaderLen], associatedData)), nil
+}
 
-		plaintext = pt
+func (a *AESGCMKeyset) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	if len(ciphertext) < aesGCMHeaderLen+aesGCMNonceLen {
+		return nil, errors.New("invalid ciphertext")
+	}
+	if ciphertext[0] != aesGCMKeysetVersion {
+		return nil, fmt.Errorf("unknown ciphertext version %d", ciphertext[0])
+	}
+
+	keyID := binary.BigEndian.Uint32(ciphertext[1:aesGCMHeaderLen])
+	k, ok := a.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("key %d: %w", keyID, ErrKeyNotFound)
 	}
-	if plaintext == nil {
-		return nil, fmt.Errorf("failed to decrypt data")
+	if k.state == KeyStateRetired {
+		return nil, fmt.Errorf("key %d: %w", keyID, ErrKeyRetired)
+	}
+
+	nonce := ciphertext[aesGCMHeaderLen : aesGCMHeaderLen+aesGCMNonceLen]
+	plaintext, err := k.aead.Open(nil, nonce, ciphertext[aesGCMHeaderLen+aesGCMNonceLen:], a.headerAD(ciphertext[:aesGCMHeaderLen], associatedData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data with key %d", keyID)
 	}
+
 	return plaintext, nil
 }
+
+func (a *AESGCMKeyset) EncryptedWithPrimary(ciphertext []byte) bool {
+	if len(ciphertext) < aesGCMHeaderLen || ciphertext[0] != aesGCMKeysetVersion {
+		return false
+	}
+	return binary.BigEndian.Uint32(ciphertext[1:aesGCMHeaderLen]) == a.primaryID
+}
+
+// headerAD binds the ciphertext header to the associated data, so the key ID
+// can not be modified.
+func (a *AESGCMKeyset) headerAD(header, associatedData []byte) []byte {
+	ad := make([]byte, 0, len(header)+len(associatedData))
+	ad = append(ad, header...)
+	return append(ad, associatedData...)
+}
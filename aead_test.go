@@ -0,0 +1,152 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestAESGCMKeyset(t *testing.T) {
+	var (
+		key1 = genAESKey()
+		key2 = genAESKey()
+		pt   = []byte("hello world")
+		ad   = []byte("ad")
+	)
+
+	ks1, err := NewAESGCMKeyset([]AESGCMKey{
+		{ID: 1, Key: key1, State: KeyStatePrimary},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ct1, err := ks1.Encrypt(pt, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ks1.Decrypt(ct1, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pt, got) {
+		t.Errorf("want %s, got %s", pt, got)
+	}
+
+	if _, err := ks1.Decrypt(ct1, []byte("other")); err == nil {
+		t.Error("decrypt with wrong associated data should fail")
+	}
+
+	tampered := bytes.Clone(ct1)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := ks1.Decrypt(tampered, ad); err == nil {
+		t.Error("decrypt of tampered data should fail")
+	}
+
+	// rotate, key 1 is now decrypt only
+	ks2, err := NewAESGCMKeyset([]AESGCMKey{
+		{ID: 1, Key: key1, State: KeyStateDecryptOnly},
+		{ID: 2, Key: key2, State: KeyStatePrimary},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = ks2.Decrypt(ct1, ad)
+	if err != nil {
+		t.Fatalf("decrypting with decrypt-only key: %v", err)
+	}
+	if !bytes.Equal(pt, got) {
+		t.Errorf("want %s, got %s", pt, got)
+	}
+
+	ct2, err := ks2.Encrypt(pt, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks1.Decrypt(ct2, ad); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("want ErrKeyNotFound decrypting with old keyset, got: %v", err)
+	}
+
+	// retire key 1
+	ks3, err := NewAESGCMKeyset([]AESGCMKey{
+		{ID: 1, Key: key1, State: KeyStateRetired},
+		{ID: 2, Key: key2, State: KeyStatePrimary},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks3.Decrypt(ct1, ad); !errors.Is(err, ErrKeyRetired) {
+		t.Errorf("want ErrKeyRetired, got: %v", err)
+	}
+	if _, err := ks3.Decrypt(ct2, ad); err != nil {
+		t.Errorf("decrypting with primary key: %v", err)
+	}
+}
+
+func TestNewAESGCMKeyset(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		keys    []AESGCMKey
+		wantErr bool
+	}{
+		{
+			name: "Valid",
+			keys: []AESGCMKey{
+				{ID: 1, Key: genAESKey(), State: KeyStatePrimary},
+				{ID: 2, Key: genAESKey(), State: KeyStateDecryptOnly},
+				{ID: 3, Key: genAESKey(), State: KeyStateRetired},
+			},
+		},
+		{
+			name:    "Empty",
+			wantErr: true,
+		},
+		{
+			name: "No primary",
+			keys: []AESGCMKey{
+				{ID: 1, Key: genAESKey(), State: KeyStateDecryptOnly},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Multiple primary",
+			keys: []AESGCMKey{
+				{ID: 1, Key: genAESKey(), State: KeyStatePrimary},
+				{ID: 2, Key: genAESKey(), State: KeyStatePrimary},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Duplicate ID",
+			keys: []AESGCMKey{
+				{ID: 1, Key: genAESKey(), State: KeyStatePrimary},
+				{ID: 1, Key: genAESKey(), State: KeyStateDecryptOnly},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Bad key length",
+			keys: []AESGCMKey{
+				{ID: 1, Key: []byte("short"), State: KeyStatePrimary},
+			},
+			wantErr: true,
+		},
+		{
+			name: "No state",
+			keys: []AESGCMKey{
+				{ID: 1, Key: genAESKey(), State: KeyStatePrimary},
+				{ID: 2, Key: genAESKey()},
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewAESGCMKeyset(tc.keys)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("NewAESGCMKeyset() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
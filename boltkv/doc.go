@@ -0,0 +1,8 @@
+// Package boltkv provides a session store on an embedded bbolt database file,
+// for single-node deployments where sessions should survive a restart.
+//
+// Items are kept in a bucket, nested under the configured bucket name, with
+// their expiry prefixed to the data. A second bucket indexes the keys by
+// expiry, so GC only visits expired items. Expired items are not returned, but
+// remain in the database until they are removed with GC or RunGC.
+package boltkv
@@ -0,0 +1,13 @@
+module github.com/lstoll/session/boltkv
+
+go 1.23
+
+require (
+	github.com/lstoll/session v0.1.0
+	go.etcd.io/bbolt v1.4.3
+)
+
+require (
+	golang.org/x/sys v0.29.0 // indirect
+	google.golang.org/protobuf v1.36.4 // indirect
+)
@@ -0,0 +1,273 @@
+package boltkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lstoll/session"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	DefaultBucketName = "web_sessions"
+
+	// gcBatchSize is the maximum number of items deleted in each GC
+	// transaction, to avoid holding the write lock for long periods.
+	gcBatchSize = 1000
+)
+
+var (
+	_ session.KV      = (*KV)(nil)
+	_ session.Toucher = (*KV)(nil)
+)
+
+var (
+	dataBucket   = []byte("data")
+	expiryBucket = []byte("expiry")
+)
+
+type KV struct {
+	db     *bolt.DB
+	bucket []byte
+	logger *slog.Logger
+}
+
+type Opts struct {
+	// BucketName is the top-level bucket the items are stored under. Defaults
+	// to DefaultBucketName.
+	BucketName string
+	// Logger is used to log background operations. If nil, nothing is logged.
+	Logger *slog.Logger
+}
+
+// New returns a KV storing items in db, creating its buckets if they do not
+// exist.
+func New(db *bolt.DB, opts *Opts) (*KV, error) {
+	bn := DefaultBucketName
+	var logger *slog.Logger
+	if opts != nil {
+		if opts.BucketName != "" {
+			bn = opts.BucketName
+		}
+		logger = opts.Logger
+	}
+	k := &KV{
+		db:     db,
+		bucket: []byte(bn),
+		logger: logger,
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(k.bucket)
+		if err != nil {
+			return err
+		}
+		if _, err := b.CreateBucketIfNotExists(dataBucket); err != nil {
+			return err
+		}
+		_, err = b.CreateBucketIfNotExists(expiryBucket)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("creating bucket %s: %w", bn, err)
+	}
+
+	return k, nil
+}
+
+func (k *KV) Get(_ context.Context, key string) (_ []byte, found bool, _ error) {
+	var data []byte
+	if err := k.db.View(func(tx *bolt.Tx) error {
+		expiresAt, v, ok := decodeItem(k.buckets(tx).data.Get([]byte(key)))
+		if !ok || !expiresAt.After(time.Now()) {
+			return nil
+		}
+		// the value is only valid for the life of the transaction.
+		data = bytes.Clone(v)
+		found = true
+		return nil
+	}); err != nil {
+		return nil, false, fmt.Errorf("getting %s: %w", key, err)
+	}
+	return data, found, nil
+}
+
+func (k *KV) Set(_ context.Context, key string, expiresAt time.Time, value []byte) error {
+	if err := k.db.Update(func(tx *bolt.Tx) error {
+		return k.buckets(tx).put([]byte(key), expiresAt, value)
+	}); err != nil {
+		return fmt.Errorf("setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// Touch updates the expiry of key, without changing the data.
+func (k *KV) Touch(_ context.Context, key string, expiresAt time.Time) error {
+	if err := k.db.Update(func(tx *bolt.Tx) error {
+		b := k.buckets(tx)
+		current, v, ok := decodeItem(b.data.Get([]byte(key)))
+		if !ok || !current.After(time.Now()) {
+			return nil
+		}
+		return b.put([]byte(key), expiresAt, bytes.Clone(v))
+	}); err != nil {
+		return fmt.Errorf("touching %s: %w", key, err)
+	}
+	return nil
+}
+
+func (k *KV) Delete(_ context.Context, key string) error {
+	if err := k.db.Update(func(tx *bolt.Tx) error {
+		return k.buckets(tx).delete([]byte(key))
+	}); err != nil {
+		return fmt.Errorf("deleting %s: %w", key, err)
+	}
+	return nil
+}
+
+// GC deletes expired items from the database. Items are deleted in batches,
+// each in their own transaction.
+func (k *KV) GC(ctx context.Context) (deleted int, _ error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, fmt.Errorf("gc: %w", err)
+		}
+
+		var n int
+		if err := k.db.Update(func(tx *bolt.Tx) error {
+			b := k.buckets(tx)
+			now := time.Now()
+
+			// collect the keys first, as deleting while iterating a cursor
+			// can skip items.
+			var expired [][]byte
+			c := b.expiry.Cursor()
+			for ik, _ := c.First(); ik != nil && len(expired) < gcBatchSize; ik, _ = c.Next() {
+				expiresAt, _ := decodeIndexKey(ik)
+				if expiresAt.After(now) {
+					break
+				}
+				expired = append(expired, bytes.Clone(ik))
+			}
+
+			for _, ik := range expired {
+				_, key := decodeIndexKey(ik)
+				if err := b.expiry.Delete(ik); err != nil {
+					return err
+				}
+				if err := b.data.Delete(key); err != nil {
+					return err
+				}
+			}
+			n = len(expired)
+			return nil
+		}); err != nil {
+			return deleted, fmt.Errorf("gc: %w", err)
+		}
+
+		deleted += n
+		if n < gcBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// RunGC starts a goroutine that calls GC every interval, until ctx is
+// canceled. Results are logged to logger, or the KV's Logger if it is nil.
+func (k *KV) RunGC(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	if logger == nil {
+		logger = k.logger
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				if logger != nil {
+					logger.InfoContext(ctx, "Garbage collection stopped", "reason", ctx.Err())
+				}
+				return
+			case <-ticker.C:
+				deleted, err := k.GC(ctx)
+				if err != nil {
+					if logger != nil {
+						logger.ErrorContext(ctx, "Garbage collection failed", "error", err)
+					}
+				} else {
+					if logger != nil {
+						logger.InfoContext(ctx, "Garbage collection successful", "deleted_rows", deleted)
+					}
+				}
+			}
+		}
+	}()
+}
+
+type buckets struct {
+	data   *bolt.Bucket
+	expiry *bolt.Bucket
+}
+
+func (k *KV) buckets(tx *bolt.Tx) buckets {
+	b := tx.Bucket(k.bucket)
+	return buckets{
+		data:   b.Bucket(dataBucket),
+		expiry: b.Bucket(expiryBucket),
+	}
+}
+
+// put stores the item, replacing its previous expiry index entry.
+func (b buckets) put(key []byte, expiresAt time.Time, value []byte) error {
+	if current, _, ok := decodeItem(b.data.Get(key)); ok {
+		if err := b.expiry.Delete(indexKey(current, key)); err != nil {
+			return err
+		}
+	}
+	if err := b.data.Put(key, encodeItem(expiresAt, value)); err != nil {
+		return err
+	}
+	return b.expiry.Put(indexKey(expiresAt, key), nil)
+}
+
+// delete removes the item and its expiry index entry.
+func (b buckets) delete(key []byte) error {
+	current, _, ok := decodeItem(b.data.Get(key))
+	if !ok {
+		return nil
+	}
+	if err := b.expiry.Delete(indexKey(current, key)); err != nil {
+		return err
+	}
+	return b.data.Delete(key)
+}
+
+// encodeItem prefixes the value with the big-endian unix nanosecond expiry.
+func encodeItem(expiresAt time.Time, value []byte) []byte {
+	b := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(b, uint64(expiresAt.UnixNano()))
+	return append(b, value...)
+}
+
+func decodeItem(b []byte) (expiresAt time.Time, value []byte, ok bool) {
+	if len(b) < 8 {
+		return time.Time{}, nil, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b[:8]))), b[8:], true
+}
+
+// indexKey returns the expiry bucket key for an item, the big-endian expiry
+// followed by the key, so the bucket is ordered by expiry.
+func indexKey(expiresAt time.Time, key []byte) []byte {
+	b := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(b, uint64(expiresAt.UnixNano()))
+	return append(b, key...)
+}
+
+func decodeIndexKey(b []byte) (expiresAt time.Time, key []byte) {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b[:8]))), b[8:]
+}
@@ -0,0 +1,194 @@
+package boltkv
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lstoll/session"
+	"github.com/lstoll/session/kvtest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestDB(t *testing.T, path string) *bolt.DB {
+	t.Helper()
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func newTestKV(t *testing.T) *KV {
+	t.Helper()
+	kv, err := New(openTestDB(t, filepath.Join(t.TempDir(), "sessions.db")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kv
+}
+
+// indexLen returns the number of entries in the expiry index.
+func indexLen(t *testing.T, kv *KV) int {
+	t.Helper()
+	var n int
+	if err := kv.db.View(func(tx *bolt.Tx) error {
+		n = kv.buckets(tx).expiry.Stats().KeyN
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestKV(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("SetGetDelete", func(t *testing.T) {
+		kv := newTestKV(t)
+
+		if err := kv.Set(ctx, "key1", time.Now().Add(time.Hour), []byte("value1")); err != nil {
+			t.Fatal(err)
+		}
+		if err := kv.Set(ctx, "key1", time.Now().Add(2*time.Hour), []byte("value2")); err != nil {
+			t.Fatal(err)
+		}
+
+		got, ok, err := kv.Get(ctx, "key1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || string(got) != "value2" {
+			t.Errorf("want value2, got %q (found %t)", got, ok)
+		}
+		if n := indexLen(t, kv); n != 1 {
+			t.Errorf("want overwritten item indexed once, got %d entries", n)
+		}
+
+		if err := kv.Delete(ctx, "key1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := kv.Get(ctx, "key1"); err != nil || ok {
+			t.Errorf("want deleted key not found, got found %t err %v", ok, err)
+		}
+		if n := indexLen(t, kv); n != 0 {
+			t.Errorf("want deleted item removed from index, got %d entries", n)
+		}
+	})
+
+	t.Run("Touch", func(t *testing.T) {
+		kv := newTestKV(t)
+
+		if err := kv.Set(ctx, "expired", time.Now().Add(-time.Minute), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		if err := kv.Set(ctx, "valid", time.Now().Add(time.Minute), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := kv.Touch(ctx, "expired", time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := kv.Get(ctx, "expired"); err != nil || ok {
+			t.Errorf("want expired key not revived by touch, got found %t err %v", ok, err)
+		}
+
+		expiresAt := time.Now().Add(time.Hour)
+		if err := kv.Touch(ctx, "valid", expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		got, ok, err := kv.Get(ctx, "valid")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || string(got) != "value" {
+			t.Errorf("want touched key kept, got %q (found %t)", got, ok)
+		}
+
+		// the index should follow the new expiry, so GC keeps the item
+		var indexed []time.Time
+		if err := kv.db.View(func(tx *bolt.Tx) error {
+			return kv.buckets(tx).expiry.ForEach(func(ik, _ []byte) error {
+				if exp, key := decodeIndexKey(ik); string(key) == "valid" {
+					indexed = append(indexed, exp)
+				}
+				return nil
+			})
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(indexed) != 1 || !indexed[0].Equal(time.Unix(0, expiresAt.UnixNano())) {
+			t.Errorf("want touched key indexed at %v, got %v", expiresAt, indexed)
+		}
+	})
+
+	t.Run("GC", func(t *testing.T) {
+		kv := newTestKV(t)
+
+		// more than a batch, to exercise multiple transactions
+		const expired = gcBatchSize + 10
+		if err := kv.db.Update(func(tx *bolt.Tx) error {
+			b := kv.buckets(tx)
+			for i := range expired {
+				if err := b.put([]byte(fmt.Sprintf("expired%d", i)), time.Now().Add(-time.Minute), []byte("value")); err != nil {
+					return err
+				}
+			}
+			return b.put([]byte("valid"), time.Now().Add(time.Hour), []byte("value"))
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		deleted, err := kv.GC(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != expired {
+			t.Errorf("want %d deleted, got %d", expired, deleted)
+		}
+		if _, ok, err := kv.Get(ctx, "valid"); err != nil || !ok {
+			t.Errorf("want valid key kept, got found %t err %v", ok, err)
+		}
+		if n := indexLen(t, kv); n != 1 {
+			t.Errorf("want 1 index entry remaining, got %d", n)
+		}
+	})
+
+	t.Run("Reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sessions.db")
+
+		db, err := bolt.Open(path, 0o600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kv, err := New(db, &Opts{BucketName: "sessions"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := kv.Set(ctx, "key1", time.Now().Add(time.Hour), []byte("value1")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		kv, err = New(openTestDB(t, path), &Opts{BucketName: "sessions"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok, err := kv.Get(ctx, "key1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || string(got) != "value1" {
+			t.Errorf("want value1 after reopen, got %q (found %t)", got, ok)
+		}
+	})
+}
+
+func TestKVConformance(t *testing.T) {
+	kvtest.TestKV(t, func(t *testing.T) session.KV { return newTestKV(t) })
+}
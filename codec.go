@@ -11,33 +11,119 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-type codec interface {
+// Codec is used to marshal and unmarshal the session data. It only needs to
+// handle the session type itself, session metadata is stored alongside the
+// encoded data in an envelope managed by the Manager.
+type Codec interface {
+	// Marshal encodes the session.
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decodes data in to v, which will be a pointer to the session
+	// type.
+	Unmarshal(data []byte, v any) error
+}
+
+var _ Codec = JSONCodec{}
+
+// JSONCodec encodes sessions with encoding/json. It is the default for session
+// types that are not protobuf messages.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+var _ Codec = ProtoCodec{}
+
+// ProtoCodec encodes sessions with protobuf binary encoding. It is the default
+// for session types that are protobuf messages. Messages are marshaled
+// deterministically, so the same session always produces the same bytes.
+type ProtoCodec struct{}
+
+func (ProtoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("failed to convert %T to proto.Message", v)
+	}
+	return protoMarshalOpts.Marshal(m)
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("failed to convert %T to proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// protoMarshalOpts are used for all protobuf marshaling, so output is stable
+// for change detection.
+var protoMarshalOpts = proto.MarshalOptions{Deterministic: true}
+
+// envelope wraps the codec-encoded session data with the session metadata.
+type envelope interface {
 	Encode(data any, md *sessionMetadata) ([]byte, error)
 	Decode(data []byte, into any) (*sessionMetadata, error)
 }
 
-var _ codec = (*protoCodec)(nil)
+// newEnvelope returns the envelope used to store data for the given codec.
+// The built-in codecs retain their original formats, other codecs have their
+// output wrapped in the protobuf envelope.
+func newEnvelope(c Codec) envelope {
+	switch c.(type) {
+	case JSONCodec, *JSONCodec:
+		return &jsonEnvelope{}
+	case ProtoCodec, *ProtoCodec:
+		return &protoEnvelope{}
+	default:
+		return &rawEnvelope{codec: c}
+	}
+}
+
+// envelopeVersion is the current version of the envelope format. Envelopes
+// written before versioning was introduced have no version, and are read as
+// version 0.
+const envelopeVersion = 1
 
 type jsonSession struct {
-	Data      json.RawMessage `json:"data"`
-	CreatedAt time.Time       `json:"createdAt"`
-	UpdatedAt time.Time       `json:"updatedAt"`
+	Version         int               `json:"version,omitempty"`
+	Data            json.RawMessage   `json:"data"`
+	ID              string            `json:"id,omitempty"`
+	UserID          string            `json:"userId,omitempty"`
+	CreatedAt       time.Time         `json:"createdAt"`
+	UpdatedAt       time.Time         `json:"updatedAt"`
+	LastRotatedAt   *time.Time        `json:"lastRotatedAt,omitempty"`
+	AuthenticatedAt *time.Time        `json:"authenticatedAt,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Flashes         []Flash           `json:"flashes,omitempty"`
+	CSRFToken       string            `json:"csrfToken,omitempty"`
 }
 
-var _ codec = (*jsonCodec)(nil)
+var _ envelope = (*jsonEnvelope)(nil)
 
-type jsonCodec struct{}
+type jsonEnvelope struct{}
 
-func (p *jsonCodec) Encode(data any, md *sessionMetadata) ([]byte, error) {
+func (p *jsonEnvelope) Encode(data any, md *sessionMetadata) ([]byte, error) {
 	bb, err := json.Marshal(data)
 	if err != nil {
 		return nil, fmt.Errorf("marshaling data: %w", err)
 	}
 
 	js := jsonSession{
-		Data:      bb,
-		CreatedAt: md.CreatedAt,
-		UpdatedAt: md.UpdatedAt,
+		Version:         envelopeVersion,
+		Data:            bb,
+		ID:              md.ID,
+		UserID:          md.UserID,
+		CreatedAt:       md.CreatedAt,
+		UpdatedAt:       md.UpdatedAt,
+		LastRotatedAt:   timePtr(md.LastRotatedAt),
+		AuthenticatedAt: timePtr(md.AuthenticatedAt),
+		Metadata:        md.Values,
+		Flashes:         md.Flashes,
+		CSRFToken:       md.CSRFToken,
 	}
 
 	sb, err := json.Marshal(&js)
@@ -48,58 +134,196 @@ func (p *jsonCodec) Encode(data any, md *sessionMetadata) ([]byte, error) {
 	return sb, err
 }
 
-func (p *jsonCodec) Decode(data []byte, into any) (*sessionMetadata, error) {
+func (p *jsonEnvelope) Decode(data []byte, into any) (*sessionMetadata, error) {
 	var js *jsonSession
 	if err := json.Unmarshal(data, &js); err != nil {
 		return nil, fmt.Errorf("unmarshaling session data: %w", err)
 	}
+	if js.Version > envelopeVersion {
+		return nil, fmt.Errorf("unsupported session envelope version %d", js.Version)
+	}
 
 	if err := json.Unmarshal(js.Data, into); err != nil {
 		return nil, fmt.Errorf("unmarshaling data: %w", err)
 	}
 
-	return &sessionMetadata{
+	md := &sessionMetadata{
+		ID:        js.ID,
+		UserID:    js.UserID,
 		CreatedAt: js.CreatedAt,
-	}, nil
+		UpdatedAt: js.UpdatedAt,
+		Values:    js.Metadata,
+		Flashes:   js.Flashes,
+		CSRFToken: js.CSRFToken,
+	}
+	if js.LastRotatedAt != nil {
+		md.LastRotatedAt = *js.LastRotatedAt
+	}
+	if js.AuthenticatedAt != nil {
+		md.AuthenticatedAt = *js.AuthenticatedAt
+	}
+
+	return md, nil
 }
 
-type protoCodec struct{}
+var _ envelope = (*protoEnvelope)(nil)
+
+type protoEnvelope struct{}
 
-func (p *protoCodec) Encode(data any, md *sessionMetadata) ([]byte, error) {
+func (p *protoEnvelope) Encode(data any, md *sessionMetadata) ([]byte, error) {
 	datapb, ok := data.(proto.Message)
 	if !ok {
 		return nil, fmt.Errorf("failed to convert %T to proto.Message", data)
 	}
-	dataany, err := anypb.New(datapb)
-	if err != nil {
+	dataany := new(anypb.Any)
+	if err := anypb.MarshalFrom(dataany, datapb, protoMarshalOpts); err != nil {
 		return nil, fmt.Errorf("encoding data as any: %w", err)
 	}
 
-	wr := sessionv1.Session_builder{
-		Data:      dataany,
-		CreatedAt: timestamppb.New(md.CreatedAt),
-		UpdatedAt: timestamppb.New(md.UpdatedAt),
-	}.Build()
+	wr := metadataToProto(md)
+	wr.SetData(dataany)
 
-	return proto.Marshal(wr)
+	return protoMarshalOpts.Marshal(wr)
 }
 
-func (p *protoCodec) Decode(data []byte, into any) (*sessionMetadata, error) {
+func (p *protoEnvelope) Decode(data []byte, into any) (*sessionMetadata, error) {
 	intopb, ok := into.(proto.Message)
 	if !ok {
 		return nil, fmt.Errorf("failed to convert %T to proto.Message", into)
 	}
 
+	spb, err := unmarshalProtoEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := spb.GetData().UnmarshalTo(intopb); err != nil {
+		return nil, fmt.Errorf("unmarshaling session data: %w", err)
+	}
+
+	return metadataFromProto(spb), nil
+}
+
+var _ envelope = (*rawEnvelope)(nil)
+
+// rawEnvelope stores the output of an arbitrary codec in the protobuf
+// envelope.
+type rawEnvelope struct {
+	codec Codec
+}
+
+func (p *rawEnvelope) Encode(data any, md *sessionMetadata) ([]byte, error) {
+	bb, err := p.codec.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling data: %w", err)
+	}
+
+	wr := metadataToProto(md)
+	wr.SetRawData(bb)
+
+	return protoMarshalOpts.Marshal(wr)
+}
+
+func (p *rawEnvelope) Decode(data []byte, into any) (*sessionMetadata, error) {
+	spb, err := unmarshalProtoEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.codec.Unmarshal(spb.GetRawData(), into); err != nil {
+		return nil, fmt.Errorf("unmarshaling session data: %w", err)
+	}
+
+	return metadataFromProto(spb), nil
+}
+
+func unmarshalProtoEnvelope(data []byte) (*sessionv1.Session, error) {
 	spb := new(sessionv1.Session)
 	if err := proto.Unmarshal(data, spb); err != nil {
 		return nil, fmt.Errorf("unmarshaling session: %w", err)
 	}
+	if spb.GetVersion() > envelopeVersion {
+		return nil, fmt.Errorf("unsupported session envelope version %d", spb.GetVersion())
+	}
+	return spb, nil
+}
 
-	if err := spb.GetData().UnmarshalTo(intopb); err != nil {
-		return nil, fmt.Errorf("unmarshaling session data: %w", err)
+// metadataToProto returns a protobuf envelope populated with the session
+// metadata.
+func metadataToProto(md *sessionMetadata) *sessionv1.Session {
+	var flashes []*sessionv1.Flash
+	for _, f := range md.Flashes {
+		flashes = append(flashes, sessionv1.Flash_builder{
+			Kind:    proto.String(f.Kind),
+			Message: proto.String(f.Message),
+		}.Build())
 	}
 
-	return &sessionMetadata{
-		CreatedAt: spb.GetCreatedAt().AsTime(),
-	}, nil
+	b := sessionv1.Session_builder{
+		Version:         proto.Int32(envelopeVersion),
+		CreatedAt:       timestampProto(md.CreatedAt),
+		UpdatedAt:       timestampProto(md.UpdatedAt),
+		LastRotatedAt:   timestampProto(md.LastRotatedAt),
+		AuthenticatedAt: timestampProto(md.AuthenticatedAt),
+		Metadata:        md.Values,
+		Flashes:         flashes,
+	}
+	if md.ID != "" {
+		b.Id = proto.String(md.ID)
+	}
+	if md.UserID != "" {
+		b.UserId = proto.String(md.UserID)
+	}
+	if md.CSRFToken != "" {
+		b.CsrfToken = proto.String(md.CSRFToken)
+	}
+
+	return b.Build()
+}
+
+func metadataFromProto(spb *sessionv1.Session) *sessionMetadata {
+	md := &sessionMetadata{
+		ID:              spb.GetId(),
+		UserID:          spb.GetUserId(),
+		CreatedAt:       timestampTime(spb.GetCreatedAt()),
+		UpdatedAt:       timestampTime(spb.GetUpdatedAt()),
+		LastRotatedAt:   timestampTime(spb.GetLastRotatedAt()),
+		AuthenticatedAt: timestampTime(spb.GetAuthenticatedAt()),
+		CSRFToken:       spb.GetCsrfToken(),
+	}
+	if len(spb.GetMetadata()) > 0 {
+		md.Values = spb.GetMetadata()
+	}
+	for _, f := range spb.GetFlashes() {
+		md.Flashes = append(md.Flashes, Flash{
+			Kind:    f.GetKind(),
+			Message: f.GetMessage(),
+		})
+	}
+	return md
+}
+
+// timestampProto converts t to a protobuf timestamp, leaving it unset if t is
+// zero.
+func timestampProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// timestampTime converts a protobuf timestamp to a time, returning the zero
+// time if it is unset.
+func timestampTime(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
 }
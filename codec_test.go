@@ -0,0 +1,185 @@
+package session
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	sessionv1 "github.com/lstoll/session/internal/proto/lstoll/session/v1"
+	testpb "github.com/lstoll/session/internal/proto/test"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	now := time.Now().Truncate(time.Millisecond).UTC()
+
+	md := &sessionMetadata{
+		ID:              "abc",
+		CreatedAt:       now.Add(-3 * time.Hour),
+		UpdatedAt:       now,
+		LastRotatedAt:   now.Add(-2 * time.Hour),
+		AuthenticatedAt: now.Add(-1 * time.Hour),
+		Values:          map[string]string{"k": "v"},
+		Flashes:         []Flash{{Kind: "info", Message: "hi"}},
+		CSRFToken:       "token",
+		UserID:          "user1",
+	}
+
+	for _, tc := range []struct {
+		name     string
+		envelope envelope
+		data     any
+		newInto  func() any
+	}{
+		{
+			name:     "JSON",
+			envelope: newEnvelope(JSONCodec{}),
+			data:     &jsonTestSession{KV: map[string]string{"a": "b"}},
+			newInto:  func() any { return &jsonTestSession{} },
+		},
+		{
+			name:     "Protobuf",
+			envelope: newEnvelope(ProtoCodec{}),
+			data:     testpb.Session_builder{Map: map[string]string{"a": "b"}}.Build(),
+			newInto:  func() any { return &testpb.Session{} },
+		},
+		{
+			name:     "Custom",
+			envelope: newEnvelope(gobCodec{}),
+			data:     &jsonTestSession{KV: map[string]string{"a": "b"}},
+			newInto:  func() any { return &jsonTestSession{} },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := tc.envelope.Encode(tc.data, md)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			into := tc.newInto()
+			got, err := tc.envelope.Decode(b, into)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertMetadataEqual(t, md, got)
+			if m := into.(codecAccessor).GetMap(); m["a"] != "b" {
+				t.Errorf("session data not decoded, got: %v", m)
+			}
+
+			// a session with only the required metadata should round trip
+			// without gaining values.
+			minmd := &sessionMetadata{ID: "abc", CreatedAt: now}
+			b, err = tc.envelope.Encode(tc.data, minmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err = tc.envelope.Decode(b, tc.newInto())
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertMetadataEqual(t, minmd, got)
+		})
+	}
+}
+
+func TestEnvelopeLegacy(t *testing.T) {
+	created := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	updated := time.Now().Truncate(time.Second).UTC()
+
+	t.Run("JSON", func(t *testing.T) {
+		legacy, err := json.Marshal(map[string]any{
+			"data":      map[string]any{"map": map[string]string{"a": "b"}},
+			"createdAt": created,
+			"updatedAt": updated,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		into := &jsonTestSession{}
+		got, err := newEnvelope(JSONCodec{}).Decode(legacy, into)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertMetadataEqual(t, &sessionMetadata{CreatedAt: created, UpdatedAt: updated}, got)
+		if into.KV["a"] != "b" {
+			t.Errorf("session data not decoded, got: %v", into.KV)
+		}
+	})
+
+	t.Run("Protobuf", func(t *testing.T) {
+		data, err := anypb.New(testpb.Session_builder{Map: map[string]string{"a": "b"}}.Build())
+		if err != nil {
+			t.Fatal(err)
+		}
+		legacy, err := proto.Marshal(sessionv1.Session_builder{
+			Data:      data,
+			CreatedAt: timestamppb.New(created),
+			UpdatedAt: timestamppb.New(updated),
+		}.Build())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		into := &testpb.Session{}
+		got, err := newEnvelope(ProtoCodec{}).Decode(legacy, into)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertMetadataEqual(t, &sessionMetadata{CreatedAt: created, UpdatedAt: updated}, got)
+		if into.GetMap()["a"] != "b" {
+			t.Errorf("session data not decoded, got: %v", into.GetMap())
+		}
+	})
+
+	t.Run("Future version", func(t *testing.T) {
+		if _, err := newEnvelope(JSONCodec{}).Decode([]byte(`{"version":99,"data":{}}`), &jsonTestSession{}); err == nil {
+			t.Error("want error decoding JSON envelope from a future version")
+		}
+
+		future, err := proto.Marshal(sessionv1.Session_builder{Version: proto.Int32(99)}.Build())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newEnvelope(ProtoCodec{}).Decode(future, &testpb.Session{}); err == nil {
+			t.Error("want error decoding proto envelope from a future version")
+		}
+	})
+}
+
+func assertMetadataEqual(t testing.TB, want, got *sessionMetadata) {
+	t.Helper()
+
+	for _, tc := range []struct {
+		name      string
+		want, got time.Time
+	}{
+		{"CreatedAt", want.CreatedAt, got.CreatedAt},
+		{"UpdatedAt", want.UpdatedAt, got.UpdatedAt},
+		{"LastRotatedAt", want.LastRotatedAt, got.LastRotatedAt},
+		{"AuthenticatedAt", want.AuthenticatedAt, got.AuthenticatedAt},
+	} {
+		if !tc.want.Equal(tc.got) {
+			t.Errorf("%s: want %s, got %s", tc.name, tc.want, tc.got)
+		}
+	}
+	if want.ID != got.ID {
+		t.Errorf("ID: want %q, got %q", want.ID, got.ID)
+	}
+	if want.UserID != got.UserID {
+		t.Errorf("UserID: want %q, got %q", want.UserID, got.UserID)
+	}
+	if want.CSRFToken != got.CSRFToken {
+		t.Errorf("CSRFToken: want %q, got %q", want.CSRFToken, got.CSRFToken)
+	}
+	if !reflect.DeepEqual(want.Values, got.Values) {
+		t.Errorf("Values: want %v, got %v", want.Values, got.Values)
+	}
+	if !reflect.DeepEqual(want.Flashes, got.Flashes) {
+		t.Errorf("Flashes: want %v, got %v", want.Flashes, got.Flashes)
+	}
+}
@@ -0,0 +1,132 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+)
+
+const (
+	// DefaultCSRFHeader is the default header the CSRF token is read from.
+	DefaultCSRFHeader = "X-CSRF-Token"
+	// DefaultCSRFFormField is the default form field the CSRF token is read
+	// from.
+	DefaultCSRFFormField = "csrf_token"
+)
+
+// ErrCSRFTokenInvalid is passed to the CSRF error handler when a request is
+// missing a valid token.
+var ErrCSRFTokenInvalid = errors.New("CSRF token missing or invalid")
+
+type CSRFOpts struct {
+	// HeaderName is the request header the token is read from. Defaults to
+	// DefaultCSRFHeader.
+	HeaderName string
+	// FormField is the form field the token is read from, if it is not in the
+	// header. Defaults to DefaultCSRFFormField.
+	FormField string
+	// ErrorHandler is called when a request fails validation. Defaults to
+	// responding with a HTTP 403.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// CSRF provides protection against cross-site request forgery, using a
+// synchronizer token bound to the session managed by a Manager. The token is
+// stored in the session metadata, and is rotated when the session is Reset or
+// Deleted.
+type CSRF[T any] struct {
+	mgr  *Manager[T]
+	opts CSRFOpts
+}
+
+// NewCSRF creates CSRF protection for sessions managed by mgr.
+func NewCSRF[T any](mgr *Manager[T], opts *CSRFOpts) *CSRF[T] {
+	c := &CSRF[T]{
+		mgr: mgr,
+		opts: CSRFOpts{
+			HeaderName: DefaultCSRFHeader,
+			FormField:  DefaultCSRFFormField,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+			},
+		},
+	}
+	if opts != nil {
+		if opts.HeaderName != "" {
+			c.opts.HeaderName = opts.HeaderName
+		}
+		if opts.FormField != "" {
+			c.opts.FormField = opts.FormField
+		}
+		if opts.ErrorHandler != nil {
+			c.opts.ErrorHandler = opts.ErrorHandler
+		}
+	}
+	return c
+}
+
+// Wrap validates the CSRF token on requests with unsafe methods, before
+// passing them to next. The handler is wrapped with the Manager if it has not
+// been already.
+func (c *CSRF[T]) Wrap(next http.Handler) http.Handler {
+	return c.mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sessCtx, ok := r.Context().Value(mgrSessCtxKey[T]{inst: c.mgr}).(*sessCtx[T])
+		if !ok {
+			panic("context contained no or invalid session")
+		}
+
+		want := sessCtx.metadata.CSRFToken
+		got := r.Header.Get(c.opts.HeaderName)
+		if got == "" {
+			got = r.PostFormValue(c.opts.FormField)
+		}
+
+		if want == "" || subtle.ConstantTimeCompare([]byte(want), []byte(got)) != 1 {
+			c.opts.ErrorHandler(w, r, ErrCSRFTokenInvalid)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// Token returns the CSRF token for the current session, to be included in
+// forms or request headers. If the session does not have a token, one is
+// created and the session is marked to be saved.
+func (c *CSRF[T]) Token(ctx context.Context) string {
+	sessCtx, ok := ctx.Value(mgrSessCtxKey[T]{inst: c.mgr}).(*sessCtx[T])
+	if !ok {
+		panic("context contained no or invalid session")
+	}
+	if sessCtx.metadata.CSRFToken == "" {
+		sessCtx.metadata.CSRFToken = newCSRFToken()
+		sessCtx.save = true
+	}
+	return sessCtx.metadata.CSRFToken
+}
+
+// TemplateField returns a hidden form input containing the CSRF token, for use
+// in templates.
+func (c *CSRF[T]) TemplateField(ctx context.Context) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(c.opts.FormField) +
+		`" value="` + template.HTMLEscapeString(c.Token(ctx)) + `">`)
+}
+
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic("err getting random")
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,106 @@
+package session
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	kvStore, err := NewKVStore(NewMemoryKV(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := NewManager[jsonTestSession](kvStore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrf := NewCSRF(mgr, nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /token", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(csrf.Token(r.Context())))
+	})
+	mux.HandleFunc("GET /field", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(csrf.TemplateField(r.Context())))
+	})
+	mux.HandleFunc("POST /submit", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /reset", func(w http.ResponseWriter, r *http.Request) {
+		mgr.Reset(r.Context(), mgr.Get(r.Context()))
+	})
+
+	svr := httptest.NewTLSServer(csrf.Wrap(mux))
+	t.Cleanup(svr.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		Transport: svr.Client().Transport,
+		Jar:       jar,
+	}
+
+	post := func(header, form string) int {
+		t.Helper()
+		var body string
+		if form != "" {
+			body = url.Values{DefaultCSRFFormField: {form}}.Encode()
+		}
+		req, err := http.NewRequest(http.MethodPost, svr.URL+"/submit", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			req.Header.Set(DefaultCSRFHeader, header)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// no session yet
+	if got := post("", ""); got != http.StatusForbidden {
+		t.Errorf("want 403 with no session token, got %d", got)
+	}
+
+	token := doReq(t, client, svr.URL+"/token", http.StatusOK)
+	if token == "" {
+		t.Fatal("no token returned")
+	}
+	if got := doReq(t, client, svr.URL+"/token", http.StatusOK); got != token {
+		t.Errorf("want token to be stable across requests, got %s and %s", token, got)
+	}
+	if field := doReq(t, client, svr.URL+"/field", http.StatusOK); !strings.Contains(field, `value="`+token+`"`) {
+		t.Errorf("template field does not contain token: %s", field)
+	}
+
+	if got := post("", ""); got != http.StatusForbidden {
+		t.Errorf("want 403 with no token, got %d", got)
+	}
+	if got := post("wrong", ""); got != http.StatusForbidden {
+		t.Errorf("want 403 with wrong token, got %d", got)
+	}
+	if got := post(token, ""); got != http.StatusOK {
+		t.Errorf("want 200 with header token, got %d", got)
+	}
+	if got := post("", token); got != http.StatusOK {
+		t.Errorf("want 200 with form token, got %d", got)
+	}
+
+	// reset should rotate the token
+	doReq(t, client, svr.URL+"/reset", http.StatusOK)
+	if got := post(token, ""); got != http.StatusForbidden {
+		t.Errorf("want 403 with token from before reset, got %d", got)
+	}
+	if newToken := doReq(t, client, svr.URL+"/token", http.StatusOK); newToken == token {
+		t.Error("want token rotated after reset")
+	}
+}
@@ -1,7 +1,9 @@
 package session
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/gob"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,17 +16,17 @@ import (
 )
 
 func TestE2E(t *testing.T) {
-	aead, err := newAESGCMAEAD(genAESKey(), nil)
+	aead, err := NewAESGCMKeyset([]AESGCMKey{{ID: 1, Key: genAESKey(), State: KeyStatePrimary}})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	cookieStore := &cookieStore{
-		AEAD:       aead,
-		cookieOpts: defaultCookieStoreCookieOpts,
+	cookieStore, err := NewCookieStore(aead, nil)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	kvStore, err := NewKVStore(&memoryKV{contents: make(map[string]kvItem)}, nil)
+	kvStore, err := NewKVStore(NewMemoryKV(), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -45,6 +47,17 @@ func TestE2E(t *testing.T) {
 		runE2ETest(t, mgr, true)
 	})
 
+	t.Run("KV Manager, Custom Codec", func(t *testing.T) {
+		mgr, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+			IdleTimeout: DefaultIdleTimeout,
+			Codec:       gobCodec{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		runE2ETest(t, mgr, true)
+	})
+
 	t.Run("Cookie Manager, JSON", func(t *testing.T) {
 		mgr, err := NewManager[jsonTestSession](cookieStore, nil)
 		if err != nil {
@@ -75,6 +88,20 @@ func (j *jsonTestSession) SetMap(m map[string]string) {
 	j.KV = m
 }
 
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
 type codecAccessor interface {
 	GetMap() map[string]string
 	SetMap(map[string]string)
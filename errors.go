@@ -0,0 +1,71 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+var (
+	// ErrSessionExpired is returned by a Store when the session it loaded has
+	// expired.
+	ErrSessionExpired = errors.New("session expired")
+	// ErrSessionInvalid is returned by a Store when the session it loaded is
+	// malformed, or has been tampered with.
+	ErrSessionInvalid = errors.New("session invalid")
+	// ErrConflict is returned when a conditional write fails, because the
+	// session was modified after it was loaded.
+	ErrConflict = errors.New("session modified concurrently")
+	// ErrLockTimeout is returned when the lock for a session could not be
+	// acquired within the Manager's LockTimeout.
+	ErrLockTimeout = errors.New("timed out waiting for session lock")
+)
+
+// Op identifies the phase of session handling an error occurred in.
+type Op string
+
+const (
+	// OpLoad indicates the session failed to load from the Store.
+	OpLoad Op = "load"
+	// OpDecode indicates the loaded session data could not be decoded.
+	OpDecode Op = "decode"
+	// OpEncode is the encoding of the session data, as reported to the
+	// Observer. Encoding failures are passed to the ErrorHandler as OpSave.
+	OpEncode Op = "encode"
+	// OpSave indicates the session failed to encode, or save to the Store.
+	OpSave Op = "save"
+	// OpDelete indicates the session failed to be deleted from the Store.
+	OpDelete Op = "delete"
+	// OpLock indicates the lock for the session could not be acquired.
+	OpLock Op = "lock"
+)
+
+// Error is passed to the error handler when the Manager fails to process a
+// session.
+type Error struct {
+	// Op is the phase that failed.
+	Op Op
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("session %s: %v", e.Op, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// DefaultErrorHandler logs the error to the default slog logger, and responds
+// with a HTTP 500. If no ErrorHandler is configured the Manager behaves the
+// same, but logs to its Logger.
+func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	logAndRespond(slog.Default(), w, r, err)
+}
+
+func logAndRespond(logger *slog.Logger, w http.ResponseWriter, r *http.Request, err error) {
+	logger.ErrorContext(r.Context(), "error in session manager", "err", err)
+	http.Error(w, "Internal Error", http.StatusInternalServerError)
+}
@@ -0,0 +1,43 @@
+package session
+
+import (
+	"context"
+)
+
+// Flash is a one-time message stored in the session, typically used to display
+// a notice to the user after a redirect.
+type Flash struct {
+	// Kind categorizes the message, e.g "info" or "error".
+	Kind string `json:"kind"`
+	// Message is the message content.
+	Message string `json:"message"`
+}
+
+// AddFlash adds a flash message to the session, to be retrieved on a later
+// request with Flashes. The session is marked to be saved at the end of the
+// request. Flashes added after Delete is called will be saved in to a new
+// session.
+func (m *Manager[T]) AddFlash(ctx context.Context, kind, msg string) {
+	sessCtx, ok := ctx.Value(mgrSessCtxKey[T]{inst: m}).(*sessCtx[T])
+	if !ok {
+		panic("context contained no or invalid session")
+	}
+	sessCtx.metadata.Flashes = append(sessCtx.metadata.Flashes, Flash{Kind: kind, Message: msg})
+	sessCtx.save = true
+}
+
+// Flashes returns the flash messages stored in the session, and removes them.
+// If there were any, the session is marked to be saved at the end of the
+// request.
+func (m *Manager[T]) Flashes(ctx context.Context) []Flash {
+	sessCtx, ok := ctx.Value(mgrSessCtxKey[T]{inst: m}).(*sessCtx[T])
+	if !ok {
+		panic("context contained no or invalid session")
+	}
+	fs := sessCtx.metadata.Flashes
+	if len(fs) > 0 {
+		sessCtx.metadata.Flashes = nil
+		sessCtx.save = true
+	}
+	return fs
+}
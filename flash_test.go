@@ -0,0 +1,79 @@
+package session
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	testpb "github.com/lstoll/session/internal/proto/test"
+)
+
+func TestFlashes(t *testing.T) {
+	kvStore, err := NewKVStore(NewMemoryKV(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		mgr, err := NewManager[jsonTestSession](kvStore, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runFlashTest(t, mgr)
+	})
+
+	t.Run("Protobuf", func(t *testing.T) {
+		mgr, err := NewManager[testpb.Session](kvStore, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runFlashTest(t, mgr)
+	})
+}
+
+func runFlashTest[T any](t *testing.T, mgr *Manager[T]) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /add", func(w http.ResponseWriter, r *http.Request) {
+		mgr.AddFlash(r.Context(), "info", "first")
+		mgr.AddFlash(r.Context(), "error", "second")
+	})
+	mux.HandleFunc("GET /logout", func(w http.ResponseWriter, r *http.Request) {
+		mgr.Delete(r.Context())
+		mgr.AddFlash(r.Context(), "info", "logged out")
+	})
+	mux.HandleFunc("GET /show", func(w http.ResponseWriter, r *http.Request) {
+		var msgs []string
+		for _, f := range mgr.Flashes(r.Context()) {
+			msgs = append(msgs, f.Kind+":"+f.Message)
+		}
+		_, _ = w.Write([]byte(strings.Join(msgs, ",")))
+	})
+
+	svr := httptest.NewTLSServer(mgr.Wrap(mux))
+	t.Cleanup(svr.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		Transport: svr.Client().Transport,
+		Jar:       jar,
+	}
+
+	doReq(t, client, svr.URL+"/add", http.StatusOK)
+	if got := doReq(t, client, svr.URL+"/show", http.StatusOK); got != "info:first,error:second" {
+		t.Errorf("want both flashes, got: %q", got)
+	}
+	if got := doReq(t, client, svr.URL+"/show", http.StatusOK); got != "" {
+		t.Errorf("want flashes removed after read, got: %q", got)
+	}
+
+	doReq(t, client, svr.URL+"/add", http.StatusOK)
+	doReq(t, client, svr.URL+"/logout", http.StatusOK)
+	if got := doReq(t, client, svr.URL+"/show", http.StatusOK); got != "info:logged out" {
+		t.Errorf("want only flash added after delete, got: %q", got)
+	}
+}
@@ -37,3 +37,19 @@ func (h *hookRW) WriteHeader(statusCode int) {
 		h.ResponseWriter.WriteHeader(statusCode)
 	}
 }
+
+// writeTrackingRW records if a response was written to it.
+type writeTrackingRW struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *writeTrackingRW) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *writeTrackingRW) WriteHeader(statusCode int) {
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
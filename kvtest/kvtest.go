@@ -0,0 +1,235 @@
+// Package kvtest implements support for testing implementations of
+// session.KV.
+package kvtest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lstoll/session"
+)
+
+// TestKV checks that a KV implementation behaves as the session.KVStore
+// expects, including the optional interfaces it implements. It is intended to
+// be called from the implementation's tests. newKV is called for each check,
+// and must return a KV that does not contain any items.
+func TestKV(t *testing.T, newKV func(t *testing.T) session.KV) {
+	ctx := context.Background()
+
+	get := func(t *testing.T, kv session.KV, key string) ([]byte, bool) {
+		t.Helper()
+		b, ok, err := kv.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%q): %v", key, err)
+		}
+		return b, ok
+	}
+	set := func(t *testing.T, kv session.KV, key string, expiresAt time.Time, value string) {
+		t.Helper()
+		if err := kv.Set(ctx, key, expiresAt, []byte(value)); err != nil {
+			t.Fatalf("Set(%q): %v", key, err)
+		}
+	}
+
+	t.Run("SetGetDelete", func(t *testing.T) {
+		kv := newKV(t)
+
+		if _, ok := get(t, kv, "key"); ok {
+			t.Error("want missing key not found")
+		}
+
+		set(t, kv, "key", time.Now().Add(time.Hour), "value1")
+		if got, ok := get(t, kv, "key"); !ok || string(got) != "value1" {
+			t.Errorf("want value1, got %q (found %t)", got, ok)
+		}
+		set(t, kv, "key", time.Now().Add(time.Hour), "value2")
+		if got, ok := get(t, kv, "key"); !ok || string(got) != "value2" {
+			t.Errorf("want overwritten value2, got %q (found %t)", got, ok)
+		}
+
+		if err := kv.Delete(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := get(t, kv, "key"); ok {
+			t.Error("want deleted key not found")
+		}
+		if err := kv.Delete(ctx, "key"); err != nil {
+			t.Errorf("want deleting missing key to succeed, got: %v", err)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		kv := newKV(t)
+
+		set(t, kv, "expired", time.Now().Add(-time.Minute), "value")
+		if _, ok := get(t, kv, "expired"); ok {
+			t.Error("want expired key not found")
+		}
+
+		// overwriting updates the expiry
+		set(t, kv, "key", time.Now().Add(time.Hour), "value")
+		set(t, kv, "key", time.Now().Add(-time.Minute), "value")
+		if _, ok := get(t, kv, "key"); ok {
+			t.Error("want key overwritten with a past expiry not found")
+		}
+	})
+
+	t.Run("Toucher", func(t *testing.T) {
+		kv := newKV(t)
+		toucher, ok := kv.(session.Toucher)
+		if !ok {
+			t.Skipf("%T does not implement Toucher", kv)
+		}
+		touch := func(t *testing.T, key string, expiresAt time.Time) {
+			t.Helper()
+			if err := toucher.Touch(ctx, key, expiresAt); err != nil {
+				t.Fatalf("Touch(%q): %v", key, err)
+			}
+		}
+
+		set(t, kv, "key", time.Now().Add(time.Minute), "value")
+		touch(t, "key", time.Now().Add(time.Hour))
+		if got, ok := get(t, kv, "key"); !ok || string(got) != "value" {
+			t.Errorf("want touched key unchanged, got %q (found %t)", got, ok)
+		}
+		touch(t, "key", time.Now().Add(-time.Minute))
+		if _, ok := get(t, kv, "key"); ok {
+			t.Error("want key touched with a past expiry not found")
+		}
+
+		set(t, kv, "expired", time.Now().Add(-time.Minute), "value")
+		touch(t, "expired", time.Now().Add(time.Hour))
+		if _, ok := get(t, kv, "expired"); ok {
+			t.Error("want expired key not revived by touch")
+		}
+
+		touch(t, "missing", time.Now().Add(time.Hour))
+		if _, ok := get(t, kv, "missing"); ok {
+			t.Error("want missing key not created by touch")
+		}
+	})
+
+	t.Run("VersionedKV", func(t *testing.T) {
+		kv := newKV(t)
+		vkv, ok := kv.(session.VersionedKV)
+		if !ok {
+			t.Skipf("%T does not implement VersionedKV", kv)
+		}
+		version := func(t *testing.T, key string) int64 {
+			t.Helper()
+			_, v, ok, err := vkv.GetVersioned(ctx, key)
+			if err != nil {
+				t.Fatalf("GetVersioned(%q): %v", key, err)
+			}
+			if !ok && v != 0 {
+				t.Errorf("want version 0 for missing key, got %d", v)
+			}
+			return v
+		}
+		cas := func(key string, v int64, value string) error {
+			return vkv.CompareAndSet(ctx, key, v, time.Now().Add(time.Hour), []byte(value))
+		}
+
+		if err := cas("key", 0, "value1"); err != nil {
+			t.Fatalf("want key created at version 0, got: %v", err)
+		}
+		if v := version(t, "key"); v != 1 {
+			t.Errorf("want created key at version 1, got %d", v)
+		}
+		if err := cas("key", 0, "value2"); !errors.Is(err, session.ErrConflict) {
+			t.Errorf("want ErrConflict creating existing key, got: %v", err)
+		}
+		if err := cas("key", 1, "value2"); err != nil {
+			t.Fatalf("want write at current version, got: %v", err)
+		}
+		if err := cas("key", 1, "value3"); !errors.Is(err, session.ErrConflict) {
+			t.Errorf("want ErrConflict writing at old version, got: %v", err)
+		}
+		if got, _ := get(t, kv, "key"); string(got) != "value2" {
+			t.Errorf("want value2, got %q", got)
+		}
+
+		set(t, kv, "key", time.Now().Add(time.Hour), "value3")
+		if v := version(t, "key"); v != 3 {
+			t.Errorf("want Set to increment version to 3, got %d", v)
+		}
+		if toucher, ok := kv.(session.Toucher); ok {
+			if err := toucher.Touch(ctx, "key", time.Now().Add(2*time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if v := version(t, "key"); v != 3 {
+				t.Errorf("want Touch to keep version 3, got %d", v)
+			}
+		}
+	})
+
+	t.Run("UserIndexer", func(t *testing.T) {
+		kv := newKV(t)
+		ui, ok := kv.(session.UserIndexer)
+		if !ok {
+			t.Skipf("%T does not implement UserIndexer", kv)
+		}
+		listIDs := func(t *testing.T, userID string) []string {
+			t.Helper()
+			sessions, err := ui.ListUser(ctx, userID)
+			if err != nil {
+				t.Fatalf("ListUser(%q): %v", userID, err)
+			}
+			var ids []string
+			for _, s := range sessions {
+				ids = append(ids, s.ID)
+			}
+			slices.Sort(ids)
+			return ids
+		}
+
+		device := session.DeviceInfo{UserAgent: "agent", IP: "192.0.2.1"}
+		for key, userID := range map[string]string{"a": "user1", "b": "user1", "c": "user2"} {
+			set(t, kv, key, time.Now().Add(time.Hour), "value")
+			if err := ui.SetUser(ctx, key, userID, device); err != nil {
+				t.Fatalf("SetUser(%q): %v", key, err)
+			}
+		}
+		set(t, kv, "expired", time.Now().Add(-time.Minute), "value")
+		if err := ui.SetUser(ctx, "expired", "user1", device); err != nil {
+			t.Fatal(err)
+		}
+
+		sessions, err := ui.ListUser(ctx, "user2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 1 || sessions[0].ID != "c" || sessions[0].Device != device || sessions[0].CreatedAt.IsZero() {
+			t.Errorf("want session c with device %v, got: %v", device, sessions)
+		}
+		if got := listIDs(t, "user1"); !slices.Equal(got, []string{"a", "b"}) {
+			t.Errorf("want user1 sessions [a b], got %v", got)
+		}
+
+		// updating the value keeps the association
+		set(t, kv, "a", time.Now().Add(time.Hour), "value2")
+		if err := kv.Delete(ctx, "b"); err != nil {
+			t.Fatal(err)
+		}
+		if got := listIDs(t, "user1"); !slices.Equal(got, []string{"a"}) {
+			t.Errorf("want user1 sessions [a] after delete, got %v", got)
+		}
+
+		deleted, err := ui.DeleteUser(ctx, "user1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != 1 {
+			t.Errorf("want 1 deleted, got %d", deleted)
+		}
+		if _, ok := get(t, kv, "a"); ok {
+			t.Error("want user's key deleted")
+		}
+		if _, ok := get(t, kv, "c"); !ok {
+			t.Error("want other user's key kept")
+		}
+	})
+}
@@ -0,0 +1,26 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+)
+
+// hashedAttr returns a log attribute containing a truncated hash of value, so
+// sessions and users can be correlated in logs without recording the
+// identifier itself.
+func hashedAttr(key, value string) slog.Attr {
+	if value == "" {
+		return slog.String(key, "")
+	}
+	h := sha256.Sum256([]byte(value))
+	return slog.String(key, hex.EncodeToString(h[:8]))
+}
+
+// loggerOrDefault returns l, or the default logger if it is nil.
+func loggerOrDefault(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
@@ -0,0 +1,122 @@
+package session
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	kvStore, err := NewKVStore(NewMemoryKV(), &KVStoreOpts{Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+		IdleTimeout: time.Hour,
+		Logger:      logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := mgr.Get(r.Context())
+		switch r.URL.Path {
+		case "/save":
+			mgr.Save(r.Context(), sess)
+		case "/login":
+			mgr.Reset(r.Context(), sess)
+			mgr.SetUserID(r.Context(), "user@example.com")
+		case "/delete":
+			mgr.Delete(r.Context())
+		}
+		ids = append(ids, mgr.Metadata(r.Context()).ID)
+	}))
+
+	var cookies []*http.Cookie
+	serve := func(path string) {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		h.ServeHTTP(rec, r)
+		if len(rec.Result().Cookies()) > 0 {
+			cookies = rec.Result().Cookies()
+			for _, c := range cookies {
+				ids = append(ids, c.Value)
+			}
+		}
+	}
+
+	for _, path := range []string{"/save", "/save", "/login", "/", "/delete"} {
+		serve(path)
+	}
+
+	logs := buf.String()
+	for _, want := range []string{
+		"Session created",
+		"Session loaded",
+		"Session saved",
+		"Session rotated",
+		"Session associated with user",
+		"Session deleted",
+	} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("want %q logged, got:\n%s", want, logs)
+		}
+	}
+	if line := logLine(logs, "Session deleted"); !strings.Contains(line, " session=") {
+		t.Errorf("want session attribute logged for delete, got: %s", line)
+	}
+	for _, id := range append(ids, "user@example.com") {
+		if id != "" && strings.Contains(logs, id) {
+			t.Errorf("raw identifier %s found in logs:\n%s", id, logs)
+		}
+	}
+
+	t.Run("Decode failure", func(t *testing.T) {
+		buf.Reset()
+		mgr, err := NewManager[jsonTestSession](&errStore{data: []byte("not json")}, &ManagerOpts[*jsonTestSession]{
+			IdleTimeout: time.Hour,
+			Logger:      logger,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+			ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("want status %d, got %d", http.StatusInternalServerError, rec.Code)
+		}
+		logs := buf.String()
+		for _, want := range []string{"Session decode failed", "error in session manager"} {
+			if !strings.Contains(logs, want) {
+				t.Errorf("want %q logged to the configured logger, got:\n%s", want, logs)
+			}
+		}
+		if line := logLine(logs, "Session decode failed"); !strings.Contains(line, " session=") {
+			t.Errorf("want session attribute logged for decode failure, got: %s", line)
+		}
+	})
+}
+
+// logLine returns the first line of logs with the message msg.
+func logLine(logs, msg string) string {
+	for _, line := range strings.Split(logs, "\n") {
+		if strings.Contains(line, "msg=\""+msg+"\"") {
+			return line
+		}
+	}
+	return ""
+}
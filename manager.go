@@ -1,9 +1,12 @@
 package session
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"time"
 
@@ -13,10 +16,29 @@ import (
 // sessionMetadata tracks additional information for the session manager to use,
 // alongside the session data itself.
 type sessionMetadata struct {
+	// ID is a random identifier for the session, used when the store does not
+	// provide one.
+	ID        string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	// LastRotatedAt is when the session was last Reset.
+	LastRotatedAt time.Time
+	// AuthenticatedAt is when the application marked the session as
+	// authenticated.
+	AuthenticatedAt time.Time
+	// Values is arbitrary application-defined metadata.
+	Values map[string]string
+	// Flashes are one-time messages stored alongside the session.
+	Flashes []Flash
+	// CSRFToken is the synchronizer token for the session, if one has been
+	// issued.
+	CSRFToken string
+	// UserID is the user the application associated the session with.
+	UserID string
 }
 
+// Store loads and saves the encoded session data for requests. Work done on
+// behalf of a request should use StoreContext for its context.
 type Store interface {
 	// GetSession loads the encoded data for a session from the request. If there is no
 	// session data, it should return nil.
@@ -30,13 +52,126 @@ type Store interface {
 	DeleteSession(w http.ResponseWriter, r *http.Request) error
 }
 
+// ResaveStore can optionally be implemented by a Store, to indicate that the
+// session loaded for the request should be written back at the end of the
+// request even if it was not modified. This is used to re-encrypt cookies that
+// were sealed with an old key.
+type ResaveStore interface {
+	Store
+	// NeedsResave is called after GetSession, and returns true if the loaded
+	// session should be saved again.
+	NeedsResave(r *http.Request) bool
+}
+
+// TouchStore can optionally be implemented by a Store that can extend the
+// expiry of a session without rewriting its data. It is used to bump the idle
+// timeout of sessions that were not modified in the request.
+type TouchStore interface {
+	Store
+	// TouchSession updates the expiry of the session loaded for the request.
+	TouchSession(w http.ResponseWriter, r *http.Request, expiresAt time.Time) error
+}
+
+// VersionedStore can optionally be implemented by a Store that can detect
+// concurrent modifications to a session. It is used when the Manager's
+// ConflictPolicy is not ConflictLastWriteWins.
+type VersionedStore interface {
+	Store
+	// PutSessionIfUnchanged saves the session like PutSession, but only if the
+	// stored session has not been modified since GetSession was called for
+	// the request. Otherwise it returns an error wrapping ErrConflict.
+	PutSessionIfUnchanged(w http.ResponseWriter, r *http.Request, expiresAt time.Time, data []byte) error
+}
+
+// LockingStore can optionally be implemented by a Store that can serialize
+// requests for the same session. It is used when the Manager's LockSessions
+// option is set.
+type LockingStore interface {
+	Store
+	// LockSession acquires an exclusive lock for the session the request
+	// refers to, waiting until it is available or ctx is done. It is called
+	// before GetSession, and the lock is held until unlock is called. If the
+	// request has no session, there is nothing to lock and it should return
+	// a no-op unlock.
+	LockSession(ctx context.Context, r *http.Request) (unlock func(), _ error)
+}
+
+// versionChecker is implemented by stores that implement VersionedStore, but
+// only support it in some configurations.
+type versionChecker interface {
+	// checkVersioned returns an error if PutSessionIfUnchanged is not
+	// supported.
+	checkVersioned() error
+}
+
+// lockChecker is implemented by stores that implement LockingStore, but only
+// support it in some configurations.
+type lockChecker interface {
+	// checkLockable returns an error if LockSession is not supported.
+	checkLockable() error
+}
+
+// nativeToucher is implemented by stores that implement TouchStore by
+// rewriting the session data in some configurations.
+type nativeToucher interface {
+	// touchesNatively returns true if TouchSession does not rewrite the
+	// session data.
+	touchesNatively() bool
+}
+
+// IDStore can optionally be implemented by a Store that tracks sessions by an
+// identifier, to expose it in the session's Metadata.
+type IDStore interface {
+	Store
+	// SessionID returns an identifier for the session associated with the
+	// context. It must be safe to log, and not usable to load the session.
+	SessionID(ctx context.Context) string
+}
+
+// UserStore can optionally be implemented by a Store that indexes sessions by
+// the user they belong to, so they can be revoked together.
+type UserStore interface {
+	Store
+	// SetSessionUser associates the session saved for the request with
+	// userID. It is called after PutSession, when the session is first tied to
+	// the user or its ID changes.
+	SetSessionUser(r *http.Request, userID string) error
+}
+
+// Metadata contains information about the current session.
+type Metadata struct {
+	// ID is a stable identifier for the session, that is safe to be logged.
+	// If the Store implements IDStore it is provided by the store, otherwise
+	// it is a random identifier stored with the session. It changes when the
+	// session is Reset or Deleted.
+	ID string
+	// CreatedAt is when the session was started.
+	CreatedAt time.Time
+	// UpdatedAt is when the session was last saved. It is zero for a new
+	// session.
+	UpdatedAt time.Time
+	// ExpiresAt is when the session will expire, if it is saved at the end of
+	// the current request.
+	ExpiresAt time.Time
+	// LastRotatedAt is when the session was last Reset. It is zero if the
+	// session has never been reset.
+	LastRotatedAt time.Time
+	// AuthenticatedAt is the time set with SetAuthenticatedAt.
+	AuthenticatedAt time.Time
+	// UserID is the user set with SetUserID.
+	UserID string
+	// Values contains the application-defined metadata set with
+	// SetMetadataValue.
+	Values map[string]string
+}
+
 // Manager is used to automatically manage a typed session. It wraps handlers,
 // and loads/saves the session type as needed. It provides methods to interact
 // with the session.
 type Manager[T any] struct {
 	store Store
 
-	codec codec
+	envelope envelope
 
 	newEmpty func() T
 
@@ -45,12 +180,91 @@ type Manager[T any] struct {
 
 var DefaultIdleTimeout = 24 * time.Hour
 
+// DefaultLockTimeout is the LockTimeout used if none is configured.
+var DefaultLockTimeout = 10 * time.Second
+
+// ConflictPolicy determines how the Manager handles a session that was
+// modified by another request, between being loaded and saved.
+type ConflictPolicy int
+
+const (
+	// ConflictLastWriteWins saves the session regardless, overwriting any
+	// changes made by other requests.
+	ConflictLastWriteWins ConflictPolicy = iota
+	// ConflictFail does not save the session, and passes an error wrapping
+	// ErrConflict to the ErrorHandler.
+	ConflictFail
+	// ConflictMerge reloads the stored session, and calls the Merge function
+	// to combine it with this request's session before trying to save again.
+	ConflictMerge
+)
+
+// maxMergeAttempts is the number of times a conflicting save is merged and
+// retried, before giving up.
+const maxMergeAttempts = 5
+
 type ManagerOpts[T any] struct {
 	MaxLifetime time.Duration
 	IdleTimeout time.Duration
+	// TouchInterval throttles how often an unmodified session is saved to
+	// extend the idle timeout. If set, the expiry is only extended when the
+	// session was last saved more than this long ago, rather than on every
+	// request. The session may expire up to this much earlier than the idle
+	// timeout. Requires IdleTimeout, and must be less than it. The session is
+	// re-saved when it is extended, so the time it was last extended is
+	// recorded.
+	TouchInterval time.Duration
 	// Onload is called when a session is retrieved from the Store. It can make
 	// any changes as needed, returning the session that should be used.
 	Onload func(T) T
+	// Codec is used to marshal and unmarshal the session data. If not set,
+	// ProtoCodec is used for session types that are protobuf messages,
+	// otherwise JSONCodec.
+	Codec Codec
+	// ErrorHandler is called when an error occurs processing the session. The
+	// error will be an *Error, indicating the phase that failed. If the handler
+	// writes a response the request is stopped, otherwise it continues without
+	// the session being loaded or saved. Defaults to logging the error to the
+	// Logger, and responding with a HTTP 500.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+	// AutoSave detects changes to the session without Save being called. At
+	// the end of the request the session is encoded and compared with the
+	// data that was loaded, and saved if it differs. This requires a Codec
+	// that produces stable output for the same session; the built-in codecs
+	// do.
+	AutoSave bool
+	// RejectInvalidSessions passes ErrSessionExpired and ErrSessionInvalid
+	// errors from the store to the ErrorHandler. By default, these sessions
+	// are discarded and a new session is started.
+	RejectInvalidSessions bool
+	// ConflictPolicy determines what happens when concurrent requests modify
+	// the same session. Policies other than ConflictLastWriteWins require the
+	// Store to implement VersionedStore. Unmodified sessions that are only
+	// being saved to extend their expiry never conflict. Defaults to
+	// ConflictLastWriteWins.
+	ConflictPolicy ConflictPolicy
+	// Merge is called for ConflictMerge with the session as currently stored,
+	// and the session from this request. It returns the session to save. The
+	// session metadata from this request is retained. If the stored session
+	// was deleted, the session is not saved.
+	Merge func(stored, current T) T
+	// LockSessions serializes requests for the same session, so only one is
+	// handled at a time. The lock is acquired before the session is loaded,
+	// and released after the request is complete. The Store must implement
+	// LockingStore.
+	LockSessions bool
+	// LockTimeout is the maximum time a request waits for the session lock.
+	// If it is exceeded, an error wrapping ErrLockTimeout is passed to the
+	// ErrorHandler. If the handler does not write a response, the request
+	// continues without holding the lock. Defaults to DefaultLockTimeout.
+	LockTimeout time.Duration
+	// Observer is notified of store and codec operations, and session
+	// lifecycle events.
+	Observer Observer
+	// Logger is used to log errors when no ErrorHandler is set, and session
+	// lifecycle events at debug level. Session IDs are hashed before they are
+	// logged. Defaults to slog.Default().
+	Logger *slog.Logger
 }
 
 func NewManager[T any, PtrT interface {
@@ -74,13 +288,57 @@ func NewManager[T any, PtrT interface {
 	if m.opts.IdleTimeout == 0 && m.opts.MaxLifetime == 0 {
 		return nil, errors.New("at least one of idle timeout or max lifetime must be specified")
 	}
+	if m.opts.TouchInterval < 0 || (m.opts.TouchInterval != 0 && m.opts.TouchInterval >= m.opts.IdleTimeout) {
+		return nil, errors.New("touch interval must be positive, and less than the idle timeout")
+	}
+	if m.opts.LockSessions {
+		if _, ok := s.(LockingStore); !ok {
+			return nil, fmt.Errorf("locking sessions requires a store implementing LockingStore, %T does not", s)
+		}
+		if lc, ok := s.(lockChecker); ok {
+			if err := lc.checkLockable(); err != nil {
+				return nil, fmt.Errorf("locking sessions requires a store that supports locking: %w", err)
+			}
+		}
+	}
+	if m.opts.LockTimeout < 0 {
+		return nil, errors.New("lock timeout must not be negative")
+	}
+	if m.opts.LockTimeout == 0 {
+		m.opts.LockTimeout = DefaultLockTimeout
+	}
+	if m.opts.Observer == nil {
+		m.opts.Observer = noopObserver{}
+	}
 
-	if _, ok := any(m.newEmpty()).(proto.Message); ok {
-		m.codec = &protoCodec{}
-	} else {
-		m.codec = &jsonCodec{}
+	switch m.opts.ConflictPolicy {
+	case ConflictLastWriteWins:
+	case ConflictFail, ConflictMerge:
+		if _, ok := s.(VersionedStore); !ok {
+			return nil, fmt.Errorf("conflict policy requires a store implementing VersionedStore, %T does not", s)
+		}
+		if vc, ok := s.(versionChecker); ok {
+			if err := vc.checkVersioned(); err != nil {
+				return nil, fmt.Errorf("conflict policy requires a store that supports versioning: %w", err)
+			}
+		}
+		if m.opts.ConflictPolicy == ConflictMerge && m.opts.Merge == nil {
+			return nil, errors.New("merge function must be set for the merge conflict policy")
+		}
+	default:
+		return nil, fmt.Errorf("unknown conflict policy %d", m.opts.ConflictPolicy)
 	}
 
+	codec := m.opts.Codec
+	if codec == nil {
+		if _, ok := any(m.newEmpty()).(proto.Message); ok {
+			codec = ProtoCodec{}
+		} else {
+			codec = JSONCodec{}
+		}
+	}
+	m.envelope = newEnvelope(codec)
+
 	return m, nil
 }
 
@@ -92,31 +350,86 @@ func (m *Manager[T]) Wrap(next http.Handler) http.Handler {
 			return
 		}
 
+		r = r.WithContext(context.WithValue(r.Context(), storeCtxKey{}, &storeCtx{}))
+
+		if m.opts.LockSessions {
+			unlock, err := m.lock(r)
+			if err != nil {
+				if m.handleErr(w, r, &Error{Op: OpLock, Err: err}) {
+					return
+				}
+			} else {
+				defer unlock()
+			}
+		}
+
 		sctx := &sessCtx[T]{
-			metadata: &sessionMetadata{
-				CreatedAt: time.Now(),
-			},
-			data: m.newEmpty(),
+			metadata: newSessionMetadata(),
+			data:     m.newEmpty(),
+			isNew:    true,
 		}
 
-		data, err := m.store.GetSession(r)
+		data, err := m.getSession(r)
 		if err != nil {
-			m.handleErr(w, r, err)
-			return
+			if errors.Is(err, ErrSessionExpired) {
+				m.opts.Observer.SessionEvent(r.Context(), EventExpired)
+			}
+			if !m.opts.RejectInvalidSessions && (errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrSessionInvalid)) {
+				m.logger().DebugContext(r.Context(), "Discarding invalid session", "err", err)
+				// start a new session, and clear the bad one at the end of
+				// the request if it isn't replaced.
+				sctx.delete = true
+				sctx.invalid = true
+			} else if m.handleErr(w, r, &Error{Op: OpLoad, Err: err}) {
+				return
+			}
+			// continue with a new session.
+			data = nil
 		}
 
+		var md *sessionMetadata
 		if data != nil {
-			md, err := m.codec.Decode(data, sctx.data)
+			md, err = m.decode(r.Context(), data, sctx.data)
 			if err != nil {
-				m.handleErr(w, r, err)
-				return
+				m.logger().DebugContext(r.Context(), "Session decode failed", m.sessionAttr(r.Context(), sctx), "err", err)
+				if m.handleErr(w, r, &Error{Op: OpDecode, Err: err}) {
+					return
+				}
+				// the error was not handled, continue with a new session.
+				sctx.data = m.newEmpty()
+				data = nil
 			}
+		}
+
+		if data != nil {
 			sctx.metadata = md
-			// track the original data if we have an idle timeout, so we can
-			// short path re-save it.
-			if m.opts.IdleTimeout != 0 {
+			sctx.isNew = false
+			if sctx.metadata.ID == "" {
+				// sessions saved before IDs were tracked
+				sctx.metadata.ID = newSID()
+			}
+			m.logger().DebugContext(r.Context(), "Session loaded", m.sessionAttr(r.Context(), sctx))
+			if rs, ok := m.store.(ResaveStore); ok && rs.NeedsResave(r) {
+				sctx.resave = true
+			}
+			// track the original data if we have an idle timeout or need to
+			// re-save, so we can short path re-save it.
+			if m.opts.IdleTimeout != 0 || sctx.resave || m.opts.AutoSave {
 				sctx.datab = data
 			}
+			if m.opts.AutoSave {
+				// the store may not return the data byte-for-byte as it was
+				// saved, e.g a JSONB column. Re-encode it, so changes are
+				// detected against the codec's own output.
+				sctx.loaded, err = m.encode(r.Context(), sctx.data, sctx.metadata)
+				if err != nil {
+					if m.handleErr(w, r, &Error{Op: OpSave, Err: err}) {
+						return
+					}
+					// changes can't be detected, so save it.
+					sctx.save = true
+				}
+			}
 			if m.opts.Onload != nil {
 				sctx.data = m.opts.Onload(sctx.data)
 			}
@@ -171,9 +484,14 @@ func (m *Manager[T]) Delete(ctx context.Context) {
 	}
 	sessCtx.datab = nil
 	sessCtx.data = m.newEmpty()
+	sessCtx.metadata = newSessionMetadata()
 	sessCtx.delete = true
 	sessCtx.save = false
 	sessCtx.reset = false
+	sessCtx.indexUser = false
+	// a session saved after this is a new one
+	sessCtx.isNew = true
+	sessCtx.invalid = false
 }
 
 // Reset rotates the session ID. Used to avoid session fixation, should be
@@ -186,46 +504,202 @@ func (m *Manager[T]) Reset(ctx context.Context, sess T) {
 	}
 	sessCtx.data = sess
 	sessCtx.datab = nil
+	// the ID and CSRF token are bound to the session, so rotate them too. A
+	// new CSRF token will be issued when next requested.
+	sessCtx.metadata.ID = newSID()
+	sessCtx.metadata.CSRFToken = ""
+	sessCtx.metadata.LastRotatedAt = time.Now()
+	// the new session needs to be indexed for the user.
+	sessCtx.indexUser = sessCtx.metadata.UserID != ""
 	sessCtx.save = false
 	sessCtx.delete = false
 	sessCtx.reset = true
 }
 
-func (m *Manager[T]) handleErr(w http.ResponseWriter, r *http.Request, err error) {
-	slog.ErrorContext(r.Context(), "error in session manager", "err", err)
-	http.Error(w, "Internal Error", http.StatusInternalServerError)
+// Metadata returns information about the current session.
+func (m *Manager[T]) Metadata(ctx context.Context) Metadata {
+	sessCtx, ok := ctx.Value(mgrSessCtxKey[T]{inst: m}).(*sessCtx[T])
+	if !ok {
+		panic("context contained no or invalid session")
+	}
+
+	md := Metadata{
+		ID:              sessCtx.metadata.ID,
+		CreatedAt:       sessCtx.metadata.CreatedAt,
+		UpdatedAt:       sessCtx.metadata.UpdatedAt,
+		LastRotatedAt:   sessCtx.metadata.LastRotatedAt,
+		AuthenticatedAt: sessCtx.metadata.AuthenticatedAt,
+		UserID:          sessCtx.metadata.UserID,
+		Values:          maps.Clone(sessCtx.metadata.Values),
+	}
+	if ids, ok := m.store.(IDStore); ok {
+		md.ID = ids.SessionID(ctx)
+	}
+
+	// the expiry is calculated when the session is saved at the end of the
+	// request, so calculate it as of now.
+	expmd := *sessCtx.metadata
+	expmd.UpdatedAt = time.Now()
+	md.ExpiresAt = m.calculateExpiry(&expmd)
+
+	return md
+}
+
+// SetAuthenticatedAt records when the user authenticated in to the session,
+// and marks the session to be saved at the end of the request.
+func (m *Manager[T]) SetAuthenticatedAt(ctx context.Context, t time.Time) {
+	sessCtx, ok := ctx.Value(mgrSessCtxKey[T]{inst: m}).(*sessCtx[T])
+	if !ok {
+		panic("context contained no or invalid session")
+	}
+	sessCtx.metadata.AuthenticatedAt = t
+	sessCtx.save = true
+}
+
+// SetUserID associates the session with a user, and marks the session to be
+// saved at the end of the request. If the Store implements UserStore, the
+// session is indexed for the user so it can be revoked along with the user's
+// other sessions. This should be called at login, alongside Reset.
+func (m *Manager[T]) SetUserID(ctx context.Context, userID string) {
+	sessCtx, ok := ctx.Value(mgrSessCtxKey[T]{inst: m}).(*sessCtx[T])
+	if !ok {
+		panic("context contained no or invalid session")
+	}
+	if sessCtx.metadata.UserID != userID {
+		sessCtx.metadata.UserID = userID
+		sessCtx.indexUser = userID != ""
+	}
+	sessCtx.save = true
+}
+
+// SetMetadataValue sets an application-defined metadata value on the session,
+// and marks the session to be saved at the end of the request. An empty value
+// removes the key.
+func (m *Manager[T]) SetMetadataValue(ctx context.Context, key, value string) {
+	sessCtx, ok := ctx.Value(mgrSessCtxKey[T]{inst: m}).(*sessCtx[T])
+	if !ok {
+		panic("context contained no or invalid session")
+	}
+	if value == "" {
+		delete(sessCtx.metadata.Values, key)
+	} else {
+		if sessCtx.metadata.Values == nil {
+			sessCtx.metadata.Values = make(map[string]string)
+		}
+		sessCtx.metadata.Values[key] = value
+	}
+	sessCtx.save = true
+}
+
+// lock acquires the store's lock for the request's session, waiting up to the
+// lock timeout.
+func (m *Manager[T]) lock(r *http.Request) (unlock func(), _ error) {
+	opCtx, end := m.startOp(r, OpLock)
+	ctx, cancel := context.WithTimeout(opCtx, m.opts.LockTimeout)
+	defer cancel()
+
+	unlock, err := m.store.(LockingStore).LockSession(ctx, r)
+	end(err)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %w", ErrLockTimeout, err)
+		}
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// sessionAttr returns a log attribute identifying the session, matching the ID
+// in its Metadata.
+func (m *Manager[T]) sessionAttr(ctx context.Context, sctx *sessCtx[T]) slog.Attr {
+	if ids, ok := m.store.(IDStore); ok {
+		return hashedAttr("session", ids.SessionID(ctx))
+	}
+	return hashedAttr("session", sctx.metadata.ID)
+}
+
+func (m *Manager[T]) logger() *slog.Logger {
+	return loggerOrDefault(m.opts.Logger)
+}
+
+// handleErr passes the error to the configured error handler. It returns true
+// if the handler wrote a response, in which case the request should not
+// continue.
+func (m *Manager[T]) handleErr(w http.ResponseWriter, r *http.Request, err error) bool {
+	eh := m.opts.ErrorHandler
+	if eh == nil {
+		eh = func(w http.ResponseWriter, r *http.Request, err error) {
+			logAndRespond(m.logger(), w, r, err)
+		}
+	}
+	tw := &writeTrackingRW{ResponseWriter: w}
+	eh(tw, r, err)
+	return tw.wrote
 }
 
 func (m *Manager[T]) saveHook(r *http.Request, sctx *sessCtx[T]) func(w http.ResponseWriter) bool {
 	return func(w http.ResponseWriter) bool {
+		lastUpdated := sctx.metadata.UpdatedAt
 		sctx.metadata.UpdatedAt = time.Now()
 
 		// if we have delete or reset, delete the session
 		if sctx.delete || sctx.reset {
-			if err := m.store.DeleteSession(w, r); err != nil {
-				m.handleErr(w, r, err)
-				return false
+			// capture the ID before the store forgets it.
+			sessAttr := m.sessionAttr(r.Context(), sctx)
+			if err := m.deleteSession(w, r); err != nil {
+				return !m.handleErr(w, r, &Error{Op: OpDelete, Err: err})
+			}
+			if sctx.delete && !sctx.invalid {
+				m.opts.Observer.SessionEvent(r.Context(), EventDeleted)
+				m.logger().DebugContext(r.Context(), "Session deleted", sessAttr)
 			}
 		}
 
-		// if we have reset or save, save the session
-		if sctx.save || sctx.reset {
-			sb, err := m.codec.Encode(sctx.data, sctx.metadata)
+		// an invalid session is being deleted, but the handler may have
+		// modified the new session that replaces it.
+		if m.opts.AutoSave && !sctx.save && !sctx.reset && (!sctx.delete || sctx.invalid) {
+			changed, err := m.changed(r.Context(), sctx, lastUpdated)
 			if err != nil {
-				m.handleErr(w, r, err)
-				return false
+				return !m.handleErr(w, r, &Error{Op: OpSave, Err: err})
 			}
+			sctx.save = changed
+		}
+
+		// if we have reset or save, save the session
+		if sctx.save || sctx.reset {
+			return m.encodeAndPut(w, r, sctx)
+		}
 
-			if err := m.store.PutSession(w, r, m.calculateExpiry(sctx.metadata), sb); err != nil {
-				m.handleErr(w, r, err)
-				return false
+		if len(sctx.datab) == 0 {
+			return true
+		}
+
+		// only touch if it won't rewrite the data, otherwise the save goes
+		// through putSession so it respects the ConflictPolicy.
+		ts, canTouch := m.store.(TouchStore)
+		if nt, ok := m.store.(nativeToucher); ok && !nt.touchesNatively() {
+			canTouch = false
+		}
+		canTouch = canTouch && !sctx.resave
+
+		if m.opts.IdleTimeout != 0 && m.opts.TouchInterval != 0 {
+			// only bump the last access time if the last bump is older than
+			// the interval. The session is re-encoded so the new last access
+			// time is recorded, touching would leave it unchanged.
+			if sctx.resave || lastUpdated.IsZero() || time.Since(lastUpdated) >= m.opts.TouchInterval {
+				return m.encodeAndPut(w, r, sctx)
 			}
-		} else if m.opts.IdleTimeout != 0 && len(sctx.datab) != 0 {
-			// always need to bump the last access time. If we weren't marked to
-			// save, do this with the original data.
-			if err := m.store.PutSession(w, r, m.calculateExpiry(sctx.metadata), sctx.datab); err != nil {
-				m.handleErr(w, r, err)
-				return false
+		} else if canTouch && m.opts.IdleTimeout != 0 {
+			// always need to bump the last access time, do it without
+			// rewriting the data if the store supports it.
+			return m.touchSession(w, r, ts, sctx)
+		} else if m.opts.IdleTimeout != 0 || sctx.resave {
+			// always need to bump the last access time, or the store asked for
+			// the session to be re-saved. If we weren't marked to save, do this
+			// with the original data. If another request has saved the session
+			// in the meantime, it already extended the expiry.
+			if err := m.putSession(w, r, m.calculateExpiry(sctx.metadata), sctx.datab); err != nil && !errors.Is(err, ErrConflict) {
+				return !m.handleErr(w, r, &Error{Op: OpSave, Err: err})
 			}
 		}
 
@@ -233,6 +707,192 @@ func (m *Manager[T]) saveHook(r *http.Request, sctx *sessCtx[T]) func(w http.Res
 	}
 }
 
+// touchSession extends the expiry of the unmodified session, without
+// rewriting its data. The stored UpdatedAt is not changed.
+func (m *Manager[T]) touchSession(w http.ResponseWriter, r *http.Request, ts TouchStore, sctx *sessCtx[T]) bool {
+	_, end := m.startOp(r, OpSave)
+	err := ts.TouchSession(w, r, m.calculateExpiry(sctx.metadata))
+	end(err)
+	if err != nil {
+		return !m.handleErr(w, r, &Error{Op: OpSave, Err: err})
+	}
+	return true
+}
+
+// changed checks if the session data was modified during the request, by
+// comparing its encoded form with the loaded session re-encoded. For new
+// sessions, it is compared with an empty session.
+func (m *Manager[T]) changed(ctx context.Context, sctx *sessCtx[T], lastUpdated time.Time) (bool, error) {
+	// encode with the metadata as it was loaded, so only data changes are
+	// detected.
+	md := *sctx.metadata
+	md.UpdatedAt = lastUpdated
+
+	sb, err := m.encode(ctx, sctx.data, &md)
+	if err != nil {
+		return false, err
+	}
+
+	orig := sctx.loaded
+	if sctx.datab == nil {
+		orig, err = m.encode(ctx, m.newEmpty(), &md)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return !bytes.Equal(sb, orig), nil
+}
+
+// encodeAndPut encodes the session, and saves it to the store.
+func (m *Manager[T]) encodeAndPut(w http.ResponseWriter, r *http.Request, sctx *sessCtx[T]) bool {
+	sb, err := m.encode(r.Context(), sctx.data, sctx.metadata)
+	if err != nil {
+		return !m.handleErr(w, r, &Error{Op: OpSave, Err: err})
+	}
+	m.opts.Observer.RecordSize(r.Context(), SizeEncoded, len(sb))
+
+	err = m.putSession(w, r, m.calculateExpiry(sctx.metadata), sb)
+	if errors.Is(err, ErrConflict) {
+		var saved bool
+		saved, err = m.resolveConflict(w, r, sctx)
+		if err == nil && !saved {
+			return true
+		}
+	}
+	if err != nil {
+		return !m.handleErr(w, r, &Error{Op: OpSave, Err: err})
+	}
+
+	sessAttr := m.sessionAttr(r.Context(), sctx)
+	if sctx.isNew {
+		m.opts.Observer.SessionEvent(r.Context(), EventCreated)
+		m.logger().DebugContext(r.Context(), "Session created", sessAttr)
+		sctx.isNew = false
+	} else if sctx.reset {
+		m.opts.Observer.SessionEvent(r.Context(), EventRotated)
+		m.logger().DebugContext(r.Context(), "Session rotated", sessAttr)
+	} else {
+		m.logger().DebugContext(r.Context(), "Session saved", sessAttr)
+	}
+
+	if us, ok := m.store.(UserStore); ok && sctx.indexUser {
+		if err := us.SetSessionUser(r, sctx.metadata.UserID); err != nil {
+			return !m.handleErr(w, r, &Error{Op: OpSave, Err: err})
+		}
+	}
+
+	return true
+}
+
+// startOp starts an Observer operation for the request. Until end is called,
+// StoreContext returns the operation's context for the request.
+func (m *Manager[T]) startOp(r *http.Request, op Op) (_ context.Context, end func(err error)) {
+	ctx, obsEnd := m.opts.Observer.StartOp(r.Context(), op)
+	sc, ok := r.Context().Value(storeCtxKey{}).(*storeCtx)
+	if !ok {
+		return ctx, obsEnd
+	}
+	prev := sc.ctx
+	sc.ctx = ctx
+	return ctx, func(err error) {
+		sc.ctx = prev
+		obsEnd(err)
+	}
+}
+
+// getSession loads the session from the store.
+func (m *Manager[T]) getSession(r *http.Request) ([]byte, error) {
+	_, end := m.startOp(r, OpLoad)
+	data, err := m.store.GetSession(r)
+	end(err)
+	return data, err
+}
+
+// putSession saves the session data to the store, only if it is unchanged if
+// a conflict policy is set.
+func (m *Manager[T]) putSession(w http.ResponseWriter, r *http.Request, expiresAt time.Time, data []byte) (err error) {
+	_, end := m.startOp(r, OpSave)
+	defer func() { end(err) }()
+
+	if vs, ok := m.store.(VersionedStore); ok && m.opts.ConflictPolicy != ConflictLastWriteWins {
+		return vs.PutSessionIfUnchanged(w, r, expiresAt, data)
+	}
+	return m.store.PutSession(w, r, expiresAt, data)
+}
+
+// deleteSession deletes the session from the store.
+func (m *Manager[T]) deleteSession(w http.ResponseWriter, r *http.Request) error {
+	_, end := m.startOp(r, OpDelete)
+	err := m.store.DeleteSession(w, r)
+	end(err)
+	return err
+}
+
+// encode encodes the session data and metadata in the envelope.
+func (m *Manager[T]) encode(ctx context.Context, data T, md *sessionMetadata) ([]byte, error) {
+	_, end := m.opts.Observer.StartOp(ctx, OpEncode)
+	b, err := m.envelope.Encode(data, md)
+	end(err)
+	return b, err
+}
+
+// decode decodes the envelope in to data, returning the metadata.
+func (m *Manager[T]) decode(ctx context.Context, b []byte, into T) (*sessionMetadata, error) {
+	_, end := m.opts.Observer.StartOp(ctx, OpDecode)
+	md, err := m.envelope.Decode(b, into)
+	end(err)
+	return md, err
+}
+
+// resolveConflict handles a failed save of a session that was modified by
+// another request, according to the conflict policy. It returns true if the
+// session was saved.
+func (m *Manager[T]) resolveConflict(w http.ResponseWriter, r *http.Request, sctx *sessCtx[T]) (bool, error) {
+	if !sctx.save && !sctx.reset {
+		// only being saved to extend the expiry, which the other request has
+		// done.
+		return false, nil
+	}
+	if m.opts.ConflictPolicy != ConflictMerge {
+		return false, ErrConflict
+	}
+
+	for range maxMergeAttempts {
+		stored, err := m.getSession(r)
+		if err != nil {
+			return false, fmt.Errorf("reloading session: %w", err)
+		}
+		if stored == nil {
+			// deleted by the other request, e.g a logout. Don't bring it back.
+			return false, nil
+		}
+		storedData := m.newEmpty()
+		if _, err := m.decode(r.Context(), stored, storedData); err != nil {
+			return false, fmt.Errorf("decoding stored session: %w", err)
+		}
+		sctx.data = m.opts.Merge(storedData, sctx.data)
+
+		sb, err := m.encode(r.Context(), sctx.data, sctx.metadata)
+		if err != nil {
+			return false, err
+		}
+		err = m.putSession(w, r, m.calculateExpiry(sctx.metadata), sb)
+		if !errors.Is(err, ErrConflict) {
+			return err == nil, err
+		}
+	}
+
+	return false, fmt.Errorf("merging after %d attempts: %w", maxMergeAttempts, ErrConflict)
+}
+
+func newSessionMetadata() *sessionMetadata {
+	return &sessionMetadata{
+		ID:        newSID(),
+		CreatedAt: time.Now(),
+	}
+}
+
 func (m *Manager[T]) calculateExpiry(md *sessionMetadata) time.Time {
 	var invalidTimes []time.Time
 
@@ -273,8 +933,22 @@ type sessCtx[T any] struct {
 	data T
 	// datab is the original loaded data bytes. Used for idle timeout, when a
 	// save may happen without data modification
-	datab  []byte
+	datab []byte
+	// loaded is the loaded session re-encoded, used by AutoSave to detect
+	// changes regardless of how the store formats the data.
+	loaded []byte
 	delete bool
 	save   bool
 	reset  bool
+	// resave indicates the store requested the loaded data be saved again,
+	// even if unmodified.
+	resave bool
+	// indexUser indicates the session needs to be associated with its user in
+	// the store when it is saved.
+	indexUser bool
+	// isNew indicates there is no stored session, so saving creates one.
+	isNew bool
+	// invalid indicates the stored session was expired or invalid, and is
+	// being deleted.
+	invalid bool
 }
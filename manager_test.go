@@ -1,8 +1,15 @@
 package session
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
+
+	testpb "github.com/lstoll/session/internal/proto/test"
 )
 
 func TestItem_InvalidAt(t *testing.T) {
@@ -83,3 +90,936 @@ func TestItem_InvalidAt(t *testing.T) {
 func ptr[T any](v T) *T {
 	return &v
 }
+
+type errStore struct {
+	getErr, putErr, deleteErr error
+	data                      []byte
+}
+
+func (e *errStore) GetSession(r *http.Request) ([]byte, error) {
+	return e.data, e.getErr
+}
+
+func (e *errStore) PutSession(w http.ResponseWriter, r *http.Request, expiresAt time.Time, data []byte) error {
+	return e.putErr
+}
+
+func (e *errStore) DeleteSession(w http.ResponseWriter, r *http.Request) error {
+	return e.deleteErr
+}
+
+// failingMarshalCodec decodes JSON, but fails to encode.
+type failingMarshalCodec struct {
+	JSONCodec
+}
+
+func (failingMarshalCodec) Marshal(v any) ([]byte, error) {
+	return nil, errors.New("marshal failed")
+}
+
+func TestErrorHandler(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	// a session stored by a working codec, that failingMarshalCodec can load.
+	rawEncoded, err := (&rawEnvelope{codec: struct{ JSONCodec }{}}).Encode(&jsonTestSession{}, &sessionMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		store       *errStore
+		handler     func(mgr *Manager[*jsonTestSession]) http.HandlerFunc
+		codec       Codec
+		autoSave    bool
+		writeErr    bool
+		wantOp      Op
+		wantStatus  int
+		wantHandled bool
+	}{
+		{
+			name:       "Load error",
+			store:      &errStore{getErr: errBoom},
+			writeErr:   true,
+			wantOp:     OpLoad,
+			wantStatus: http.StatusTeapot,
+		},
+		{
+			name:        "Load error, continue",
+			store:       &errStore{getErr: errBoom},
+			wantOp:      OpLoad,
+			wantStatus:  http.StatusOK,
+			wantHandled: true,
+		},
+		{
+			name:       "Decode error",
+			store:      &errStore{data: []byte("not json")},
+			writeErr:   true,
+			wantOp:     OpDecode,
+			wantStatus: http.StatusTeapot,
+		},
+		{
+			name:        "Decode error, continue",
+			store:       &errStore{data: []byte("not json")},
+			wantOp:      OpDecode,
+			wantStatus:  http.StatusOK,
+			wantHandled: true,
+		},
+		{
+			name:  "Save error",
+			store: &errStore{putErr: errBoom},
+			handler: func(mgr *Manager[*jsonTestSession]) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					mgr.Save(r.Context(), &jsonTestSession{})
+				}
+			},
+			writeErr:   true,
+			wantOp:     OpSave,
+			wantStatus: http.StatusTeapot,
+		},
+		{
+			name:  "Encode error",
+			store: &errStore{},
+			codec: failingMarshalCodec{},
+			handler: func(mgr *Manager[*jsonTestSession]) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					mgr.Save(r.Context(), &jsonTestSession{})
+				}
+			},
+			writeErr:   true,
+			wantOp:     OpSave,
+			wantStatus: http.StatusTeapot,
+		},
+		{
+			// the loaded session is re-encoded to detect changes.
+			name:       "Encode error, auto save",
+			store:      &errStore{data: rawEncoded},
+			codec:      failingMarshalCodec{},
+			autoSave:   true,
+			writeErr:   true,
+			wantOp:     OpSave,
+			wantStatus: http.StatusTeapot,
+		},
+		{
+			name:  "Delete error",
+			store: &errStore{deleteErr: errBoom},
+			handler: func(mgr *Manager[*jsonTestSession]) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					mgr.Delete(r.Context())
+					_, _ = w.Write([]byte("deleted"))
+				}
+			},
+			writeErr:   true,
+			wantOp:     OpDelete,
+			wantStatus: http.StatusTeapot,
+		},
+		{
+			name:  "Delete error, continue",
+			store: &errStore{deleteErr: errBoom},
+			handler: func(mgr *Manager[*jsonTestSession]) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					mgr.Delete(r.Context())
+					_, _ = w.Write([]byte("deleted"))
+				}
+			},
+			wantOp:     OpDelete,
+			wantStatus: http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotErr error
+			mgr, err := NewManager[jsonTestSession](tc.store, &ManagerOpts[*jsonTestSession]{
+				IdleTimeout: time.Hour,
+				Codec:       tc.codec,
+				AutoSave:    tc.autoSave,
+				ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+					gotErr = err
+					if tc.writeErr {
+						w.WriteHeader(http.StatusTeapot)
+					}
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var handled bool
+			h := func(w http.ResponseWriter, r *http.Request) {
+				handled = true
+				if tc.handler != nil {
+					tc.handler(mgr)(w, r)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			mgr.Wrap(http.HandlerFunc(h)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("want status %d, got %d", tc.wantStatus, rec.Code)
+			}
+			var serr *Error
+			if !errors.As(gotErr, &serr) {
+				t.Fatalf("want *Error, got %T: %v", gotErr, gotErr)
+			}
+			if serr.Op != tc.wantOp {
+				t.Errorf("want op %s, got %s", tc.wantOp, serr.Op)
+			}
+			if tc.handler == nil && handled != tc.wantHandled {
+				t.Errorf("want handler called %t, got %t", tc.wantHandled, handled)
+			}
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	aead, err := NewAESGCMKeyset([]AESGCMKey{{ID: 1, Key: genAESKey(), State: KeyStatePrimary}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookieStore, err := NewCookieStore(aead, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvStore, err := NewKVStore(NewMemoryKV(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		store Store
+	}{
+		{name: "Cookie", store: cookieStore},
+		{name: "KV", store: kvStore},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mgr, err := NewManager[jsonTestSession](tc.store, &ManagerOpts[*jsonTestSession]{
+				IdleTimeout: time.Hour,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				md    Metadata
+				reset bool
+			)
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if reset {
+					mgr.Reset(r.Context(), mgr.Get(r.Context()))
+				} else {
+					mgr.Save(r.Context(), mgr.Get(r.Context()))
+				}
+				md = mgr.Metadata(r.Context())
+			}))
+
+			var cookies []*http.Cookie
+			serve := func() {
+				rec := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				for _, c := range cookies {
+					r.AddCookie(c)
+				}
+				h.ServeHTTP(rec, r)
+				if len(rec.Result().Cookies()) > 0 {
+					cookies = rec.Result().Cookies()
+				}
+			}
+
+			serve()
+			first := md
+			if first.ID == "" {
+				t.Error("want session ID set")
+			}
+			if first.CreatedAt.IsZero() {
+				t.Error("want created at set")
+			}
+			if d := time.Until(first.ExpiresAt); d < 59*time.Minute || d > time.Hour {
+				t.Errorf("want expiry in an hour, got %s", d)
+			}
+
+			serve()
+			if md.ID != first.ID {
+				t.Errorf("want stable ID %s, got %s", first.ID, md.ID)
+			}
+			if !md.CreatedAt.Equal(first.CreatedAt) {
+				t.Errorf("want stable created at %s, got %s", first.CreatedAt, md.CreatedAt)
+			}
+			if md.UpdatedAt.IsZero() {
+				t.Error("want updated at loaded from the saved session")
+			}
+
+			reset = true
+			serve()
+			reset = false
+			serve()
+			if md.ID == first.ID {
+				t.Error("want ID changed after reset")
+			}
+			if md.LastRotatedAt.IsZero() {
+				t.Error("want last rotated at set after reset")
+			}
+		})
+	}
+}
+
+type countingKV struct {
+	KV
+	sets    int
+	touches int
+}
+
+func (c *countingKV) Set(ctx context.Context, key string, expiresAt time.Time, value []byte) error {
+	c.sets++
+	return c.KV.Set(ctx, key, expiresAt, value)
+}
+
+// countingToucherKV is a countingKV that exposes the underlying KV's Touch.
+type countingToucherKV struct {
+	*countingKV
+}
+
+func (c *countingToucherKV) Touch(ctx context.Context, key string, expiresAt time.Time) error {
+	c.touches++
+	return c.KV.(Toucher).Touch(ctx, key, expiresAt)
+}
+
+func TestTouchInterval(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		touchInterval time.Duration
+		toucher       bool
+		wantSets      int
+		wantTouches   int
+	}{
+		{
+			name:     "No interval",
+			wantSets: 5,
+		},
+		{
+			name:        "No interval, toucher",
+			toucher:     true,
+			wantSets:    1,
+			wantTouches: 4,
+		},
+		{
+			name:          "Interval",
+			touchInterval: 50 * time.Millisecond,
+			wantSets:      2,
+		},
+		{
+			// touching wouldn't record UpdatedAt, so the session is saved
+			// once per interval.
+			name:          "Interval, toucher",
+			touchInterval: 50 * time.Millisecond,
+			toucher:       true,
+			wantSets:      2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			kv := &countingKV{KV: NewMemoryKV()}
+			var storeKV KV = kv
+			if tc.toucher {
+				storeKV = &countingToucherKV{countingKV: kv}
+			}
+			kvStore, err := NewKVStore(storeKV, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mgr, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+				IdleTimeout:   time.Hour,
+				TouchInterval: tc.touchInterval,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				cookies []*http.Cookie
+				save    bool
+				md      Metadata
+			)
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if save {
+					mgr.Save(r.Context(), mgr.Get(r.Context()))
+				}
+				md = mgr.Metadata(r.Context())
+			}))
+			serve := func() {
+				rec := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				for _, c := range cookies {
+					r.AddCookie(c)
+				}
+				h.ServeHTTP(rec, r)
+				if len(rec.Result().Cookies()) > 0 {
+					cookies = rec.Result().Cookies()
+				}
+			}
+
+			save = true
+			serve()
+			save = false
+			serve()
+			serve()
+
+			// after the interval, the session should be bumped once
+			time.Sleep(60 * time.Millisecond)
+			serve()
+			bumpedAt := time.Now()
+			serve()
+			if tc.touchInterval != 0 && md.UpdatedAt.Before(bumpedAt.Add(-tc.touchInterval)) {
+				t.Errorf("want updated at recorded by the previous bump, got %s", md.UpdatedAt)
+			}
+
+			if kv.sets != tc.wantSets {
+				t.Errorf("want %d sets, got %d", tc.wantSets, kv.sets)
+			}
+			if kv.touches != tc.wantTouches {
+				t.Errorf("want %d touches, got %d", tc.wantTouches, kv.touches)
+			}
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, opts := range []*ManagerOpts[*jsonTestSession]{
+			{IdleTimeout: time.Hour, TouchInterval: -time.Minute},
+			{IdleTimeout: time.Hour, TouchInterval: time.Hour},
+			{MaxLifetime: time.Hour, TouchInterval: time.Minute},
+		} {
+			if _, err := NewManager[jsonTestSession](&errStore{}, opts); err == nil {
+				t.Errorf("want error for idle timeout %s and touch interval %s", opts.IdleTimeout, opts.TouchInterval)
+			}
+		}
+	})
+}
+
+func TestAutoSave(t *testing.T) {
+	t.Run("JSON", func(t *testing.T) {
+		runAutoSaveTest(t, func(s Store) *Manager[*jsonTestSession] {
+			mgr, err := NewManager[jsonTestSession](s, &ManagerOpts[*jsonTestSession]{
+				MaxLifetime: time.Hour,
+				AutoSave:    true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return mgr
+		}, nil)
+	})
+
+	t.Run("JSON reformatted by store", func(t *testing.T) {
+		runAutoSaveTest(t, func(s Store) *Manager[*jsonTestSession] {
+			mgr, err := NewManager[jsonTestSession](s, &ManagerOpts[*jsonTestSession]{
+				MaxLifetime: time.Hour,
+				AutoSave:    true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return mgr
+		}, func(kv KV) KV { return &reformattingKV{KV: kv} })
+	})
+
+	t.Run("Invalid session", func(t *testing.T) {
+		aead, err := NewAESGCMKeyset([]AESGCMKey{{ID: 1, Key: genAESKey(), State: KeyStatePrimary}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs, err := NewCookieStore(aead, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mgr, err := NewManager[jsonTestSession](cs, &ManagerOpts[*jsonTestSession]{
+			MaxLifetime: time.Hour,
+			AutoSave:    true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expired := httptest.NewRecorder()
+		if err := cs.PutSession(expired, httptest.NewRequest(http.MethodGet, "/", nil), time.Now().Add(-time.Minute), []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+
+		var got map[string]string
+		h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sess := mgr.Get(r.Context())
+			got = sess.KV
+			if r.URL.Query().Has("set") {
+				sess.KV = map[string]string{"a": "1"}
+			}
+		}))
+
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/?set", nil)
+		r.AddCookie(expired.Result().Cookies()[0])
+		h.ServeHTTP(rec, r)
+		cookies := rec.Result().Cookies()
+		if len(cookies) == 0 || cookies[len(cookies)-1].MaxAge < 0 {
+			t.Fatalf("want modified session saved in place of the expired one, got cookies: %v", cookies)
+		}
+
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(cookies[len(cookies)-1])
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if got["a"] != "1" {
+			t.Errorf("want modified value loaded, got: %v", got)
+		}
+	})
+
+	t.Run("Protobuf", func(t *testing.T) {
+		runAutoSaveTest(t, func(s Store) *Manager[*testpb.Session] {
+			mgr, err := NewManager[testpb.Session](s, &ManagerOpts[*testpb.Session]{
+				MaxLifetime: time.Hour,
+				AutoSave:    true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return mgr
+		}, nil)
+	})
+}
+
+// reformattingKV returns JSON values with the keys sorted and indented, like
+// a database storing them as JSONB would.
+type reformattingKV struct {
+	KV
+}
+
+func (r *reformattingKV) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	b, ok, err := r.KV.Get(ctx, key)
+	if err != nil || !ok {
+		return b, ok, err
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, false, err
+	}
+	b, err = json.MarshalIndent(v, "", "  ")
+	return b, err == nil, err
+}
+
+// runAutoSaveTest runs the AutoSave checks with a manager from newMgr. If
+// wrapKV is set, the store's KV is wrapped with it.
+func runAutoSaveTest[T codecAccessor](t *testing.T, newMgr func(Store) *Manager[T], wrapKV func(KV) KV) {
+	kv := &countingKV{KV: NewMemoryKV()}
+	var storeKV KV = kv
+	if wrapKV != nil {
+		storeKV = wrapKV(kv)
+	}
+	kvStore, err := NewKVStore(storeKV, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := newMgr(kvStore)
+
+	var (
+		cookies []*http.Cookie
+		set     map[string]string
+		got     map[string]string
+	)
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := mgr.Get(r.Context())
+		got = sess.GetMap()
+		if set != nil {
+			m := sess.GetMap()
+			if m == nil {
+				m = make(map[string]string)
+			}
+			for k, v := range set {
+				m[k] = v
+			}
+			sess.SetMap(m)
+		}
+	}))
+	serve := func() {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		h.ServeHTTP(rec, r)
+		if len(rec.Result().Cookies()) > 0 {
+			cookies = rec.Result().Cookies()
+		}
+	}
+
+	// unmodified new session should not be saved
+	serve()
+	if kv.sets != 0 {
+		t.Fatalf("want no save for unmodified new session, got %d", kv.sets)
+	}
+
+	// modifying without calling save should be detected
+	set = map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}
+	serve()
+	if kv.sets != 1 {
+		t.Fatalf("want session saved after modification, got %d sets", kv.sets)
+	}
+
+	// unmodified loaded session should not be saved
+	set = nil
+	for range 5 {
+		serve()
+	}
+	if got["a"] != "1" || got["d"] != "4" {
+		t.Errorf("want modified values loaded, got: %v", got)
+	}
+	if kv.sets != 1 {
+		t.Errorf("want no save for unmodified session, got %d sets", kv.sets)
+	}
+
+	set = map[string]string{"a": "5"}
+	serve()
+	set = nil
+	serve()
+	if got["a"] != "5" {
+		t.Errorf("want modified value loaded, got: %v", got)
+	}
+	if kv.sets != 2 {
+		t.Errorf("want 2 sets, got %d", kv.sets)
+	}
+}
+
+func TestConflictPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		policy   ConflictPolicy
+		merge    func(stored, current *jsonTestSession) *jsonTestSession
+		wantKeys []string
+		wantErr  bool
+	}{
+		{
+			name:     "Last write wins",
+			policy:   ConflictLastWriteWins,
+			wantKeys: []string{"init", "outer"},
+		},
+		{
+			name:     "Fail",
+			policy:   ConflictFail,
+			wantKeys: []string{"init", "inner"},
+			wantErr:  true,
+		},
+		{
+			name:   "Merge",
+			policy: ConflictMerge,
+			merge: func(stored, current *jsonTestSession) *jsonTestSession {
+				for k, v := range stored.KV {
+					current.KV[k] = v
+				}
+				return current
+			},
+			wantKeys: []string{"init", "inner", "outer"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			kvStore, err := NewKVStore(NewMemoryKV(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotErr error
+			mgr, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+				IdleTimeout:    time.Hour,
+				ConflictPolicy: tc.policy,
+				Merge:          tc.merge,
+				ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+					gotErr = err
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				cookies []*http.Cookie
+				h       http.Handler
+				got     map[string]string
+			)
+			serve := func(path string) {
+				rec := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, path, nil)
+				for _, c := range cookies {
+					r.AddCookie(c)
+				}
+				h.ServeHTTP(rec, r)
+				if len(rec.Result().Cookies()) > 0 {
+					cookies = rec.Result().Cookies()
+				}
+			}
+			h = mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sess := mgr.Get(r.Context())
+				got = sess.KV
+				switch r.URL.Path {
+				case "/init":
+					sess.KV = map[string]string{"init": "1"}
+				case "/inner":
+					sess.KV["inner"] = "1"
+				case "/outer":
+					// another request modifies the session while this one
+					// is in progress.
+					serve("/inner")
+					sess.KV["outer"] = "1"
+				default:
+					return
+				}
+				mgr.Save(r.Context(), sess)
+			}))
+
+			serve("/init")
+			serve("/outer")
+			if tc.wantErr != errors.Is(gotErr, ErrConflict) {
+				t.Errorf("want conflict error %t, got: %v", tc.wantErr, gotErr)
+			}
+
+			serve("/")
+			for _, k := range tc.wantKeys {
+				if _, ok := got[k]; !ok {
+					t.Errorf("want key %s in session, got: %v", k, got)
+				}
+			}
+			if len(got) != len(tc.wantKeys) {
+				t.Errorf("want keys %v, got: %v", tc.wantKeys, got)
+			}
+		})
+	}
+
+	t.Run("Unsupported store", func(t *testing.T) {
+		aead, err := NewAESGCMKeyset([]AESGCMKey{{ID: 1, Key: genAESKey(), State: KeyStatePrimary}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookieStore, err := NewCookieStore(aead, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewManager[jsonTestSession](cookieStore, &ManagerOpts[*jsonTestSession]{
+			IdleTimeout:    time.Hour,
+			ConflictPolicy: ConflictFail,
+		}); err == nil {
+			t.Error("want error for store without versioning")
+		}
+
+		// countingKV only exposes the methods of KV.
+		kvStore, err := NewKVStore(&countingKV{KV: NewMemoryKV()}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, policy := range []ConflictPolicy{ConflictFail, ConflictMerge} {
+			if _, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+				IdleTimeout:    time.Hour,
+				ConflictPolicy: policy,
+				Merge:          func(stored, current *jsonTestSession) *jsonTestSession { return current },
+			}); err == nil {
+				t.Errorf("want error for policy %d with KV without versioning", policy)
+			}
+		}
+	})
+
+	t.Run("Unmodified, KV without Toucher", func(t *testing.T) {
+		// extending the expiry has to rewrite the data, which must not
+		// overwrite changes made by another request.
+		kvStore, err := NewKVStore(&versionedKV{KV: NewMemoryKV()}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mgr, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+			IdleTimeout:    time.Hour,
+			ConflictPolicy: ConflictFail,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			cookies []*http.Cookie
+			h       http.Handler
+			got     map[string]string
+		)
+		serve := func(path string) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			for _, c := range cookies {
+				r.AddCookie(c)
+			}
+			h.ServeHTTP(rec, r)
+			if len(rec.Result().Cookies()) > 0 {
+				cookies = rec.Result().Cookies()
+			}
+		}
+		h = mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sess := mgr.Get(r.Context())
+			got = sess.KV
+			switch r.URL.Path {
+			case "/init":
+				sess.KV = map[string]string{"init": "1"}
+				mgr.Save(r.Context(), sess)
+			case "/inner":
+				sess.KV["inner"] = "1"
+				mgr.Save(r.Context(), sess)
+			case "/outer":
+				serve("/inner")
+			}
+		}))
+
+		serve("/init")
+		serve("/outer")
+		serve("/")
+		if _, ok := got["inner"]; !ok {
+			t.Errorf("want key inner kept in session, got: %v", got)
+		}
+	})
+}
+
+// versionedKV only exposes the methods of KV and VersionedKV.
+type versionedKV struct {
+	KV
+}
+
+func (v *versionedKV) GetVersioned(ctx context.Context, key string) ([]byte, int64, bool, error) {
+	return v.KV.(VersionedKV).GetVersioned(ctx, key)
+}
+
+func (v *versionedKV) CompareAndSet(ctx context.Context, key string, version int64, expiresAt time.Time, value []byte) error {
+	return v.KV.(VersionedKV).CompareAndSet(ctx, key, version, expiresAt, value)
+}
+
+func TestLockSessions(t *testing.T) {
+	kvStore, err := NewKVStore(NewMemoryKV(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	mgr, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+		IdleTimeout:  time.Hour,
+		LockSessions: true,
+		LockTimeout:  100 * time.Millisecond,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			errc <- err
+			http.Error(w, "Conflict", http.StatusConflict)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		entered = make(chan struct{})
+		release = make(chan struct{})
+	)
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := mgr.Get(r.Context())
+		if r.URL.Path == "/block" {
+			entered <- struct{}{}
+			<-release
+		}
+		if sess.KV == nil {
+			sess.KV = map[string]string{}
+		}
+		sess.KV[r.URL.Path] = "1"
+		mgr.Save(r.Context(), sess)
+	}))
+
+	var cookies []*http.Cookie
+	serve := func(path string) int {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	// establish the session
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/init", nil))
+	cookies = rec.Result().Cookies()
+
+	t.Run("Serialized", func(t *testing.T) {
+		done := make(chan int)
+		go func() { done <- serve("/block") }()
+		<-entered
+
+		second := make(chan int)
+		go func() { second <- serve("/second") }()
+
+		select {
+		case <-second:
+			t.Fatal("second request should wait for the lock")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		close(release)
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("first request: want status %d, got %d", http.StatusOK, code)
+		}
+		if code := <-second; code != http.StatusOK {
+			t.Errorf("second request: want status %d, got %d", http.StatusOK, code)
+		}
+		select {
+		case err := <-errc:
+			t.Errorf("want no error, got: %v", err)
+		default:
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		release = make(chan struct{})
+		done := make(chan int)
+		go func() { done <- serve("/block") }()
+		<-entered
+
+		if code := serve("/second"); code != http.StatusConflict {
+			t.Errorf("want status %d, got %d", http.StatusConflict, code)
+		}
+		if err := <-errc; !errors.Is(err, ErrLockTimeout) {
+			t.Errorf("want ErrLockTimeout, got: %v", err)
+		}
+
+		close(release)
+		<-done
+	})
+
+	t.Run("Unsupported store", func(t *testing.T) {
+		// countingKV only exposes the methods of KV.
+		kvStore, err := NewKVStore(&countingKV{KV: NewMemoryKV()}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+			IdleTimeout:  time.Hour,
+			LockSessions: true,
+		}); err == nil {
+			t.Error("want error for KV without locking")
+		}
+
+		kvStore, err = NewKVStore(&unlockableKV{KV: NewMemoryKV()}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+			IdleTimeout:  time.Hour,
+			LockSessions: true,
+		}); err == nil {
+			t.Error("want error for KV that can't lock")
+		}
+	})
+}
+
+// unlockableKV implements Locker, but reports that it can't lock.
+type unlockableKV struct {
+	KV
+}
+
+func (u *unlockableKV) Lock(ctx context.Context, key string) (func(), error) {
+	return u.KV.(Locker).Lock(ctx, key)
+}
+
+func (u *unlockableKV) CheckLockable() error {
+	return errors.New("not configured for locking")
+}
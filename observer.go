@@ -0,0 +1,80 @@
+package session
+
+import (
+	"context"
+	"net/http"
+)
+
+// Observer can be set on the Manager and stores to instrument session
+// handling, e.g to record traces and metrics. Implementations must be safe for
+// concurrent use. The github.com/lstoll/session/otelsession module provides an
+// OpenTelemetry implementation.
+type Observer interface {
+	// StartOp is called when an operation starts. It returns the context for
+	// the operation, which stores use for the work they do via StoreContext,
+	// so it can be associated with the operation, e.g as child spans. The
+	// returned function is called when it completes, with the error if it
+	// failed.
+	StartOp(ctx context.Context, op Op) (_ context.Context, end func(err error))
+	// SessionEvent is called when a lifecycle event occurs for a session.
+	SessionEvent(ctx context.Context, event Event)
+	// RecordSize is called with the size in bytes of encoded session data.
+	RecordSize(ctx context.Context, kind SizeKind, size int)
+}
+
+// Event is a session lifecycle event.
+type Event string
+
+const (
+	// EventCreated indicates a new session was saved for the first time.
+	EventCreated Event = "created"
+	// EventRotated indicates a session was Reset, and saved under a new ID.
+	EventRotated Event = "rotated"
+	// EventDeleted indicates a session was deleted with Delete.
+	EventDeleted Event = "deleted"
+	// EventExpired indicates the store returned an expired session, or for
+	// the KVStore that the session referred to by the request's cookie no
+	// longer exists.
+	EventExpired Event = "expired"
+)
+
+// SizeKind identifies what is being measured by Observer.RecordSize.
+type SizeKind string
+
+const (
+	// SizeEncoded is the size of the session encoded by the Manager, before it
+	// is passed to the store.
+	SizeEncoded SizeKind = "encoded"
+	// SizeCookie is the total size of the cookie names and values written by
+	// the CookieStore, across all chunks.
+	SizeCookie SizeKind = "cookie"
+)
+
+// noopObserver is used when no Observer is configured.
+type noopObserver struct{}
+
+func (noopObserver) StartOp(ctx context.Context, _ Op) (context.Context, func(error)) {
+	return ctx, func(error) {}
+}
+func (noopObserver) SessionEvent(context.Context, Event)       {}
+func (noopObserver) RecordSize(context.Context, SizeKind, int) {}
+
+// storeCtxKey is the context key for the request's *storeCtx.
+type storeCtxKey struct{}
+
+// storeCtx tracks the context of the Manager operation in progress for a
+// request.
+type storeCtx struct {
+	ctx context.Context
+}
+
+// StoreContext returns the context a Store should use for work done on behalf
+// of the request. While the Manager is calling the store, it is the context
+// returned by the Observer for the operation. Otherwise, it is the request's
+// context.
+func StoreContext(r *http.Request) context.Context {
+	if sc, ok := r.Context().Value(storeCtxKey{}).(*storeCtx); ok && sc.ctx != nil {
+		return sc.ctx
+	}
+	return r.Context()
+}
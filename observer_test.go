@@ -0,0 +1,221 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	mu     sync.Mutex
+	ops    []Op
+	failed []Op
+	events []Event
+	sizes  map[SizeKind]int
+}
+
+// recordingOpKey is set on the context returned by recordingObserver.StartOp,
+// to the operation.
+type recordingOpKey struct{}
+
+func (o *recordingObserver) StartOp(ctx context.Context, op Op) (context.Context, func(error)) {
+	return context.WithValue(ctx, recordingOpKey{}, op), func(err error) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		o.ops = append(o.ops, op)
+		if err != nil {
+			o.failed = append(o.failed, op)
+		}
+	}
+}
+
+func (o *recordingObserver) SessionEvent(_ context.Context, event Event) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event)
+}
+
+func (o *recordingObserver) RecordSize(_ context.Context, kind SizeKind, size int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.sizes == nil {
+		o.sizes = make(map[SizeKind]int)
+	}
+	o.sizes[kind] = size
+}
+
+func (o *recordingObserver) reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ops, o.failed, o.events, o.sizes = nil, nil, nil, nil
+}
+
+func TestObserver(t *testing.T) {
+	obs := &recordingObserver{}
+
+	aead, err := NewAESGCMKeyset([]AESGCMKey{{ID: 1, Key: genAESKey(), State: KeyStatePrimary}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewCookieStore(aead, &CookieStoreOpts{Observer: obs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := NewManager[jsonTestSession](store, &ManagerOpts[*jsonTestSession]{
+		MaxLifetime: time.Hour,
+		Observer:    obs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := mgr.Get(r.Context())
+		switch r.URL.Path {
+		case "/save":
+			sess.KV = map[string]string{"k": "v"}
+			mgr.Save(r.Context(), sess)
+		case "/reset":
+			mgr.Reset(r.Context(), sess)
+		case "/delete":
+			mgr.Delete(r.Context())
+		}
+	}))
+
+	var cookies []*http.Cookie
+	serve := func(path string) {
+		obs.reset()
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		h.ServeHTTP(rec, r)
+		if len(rec.Result().Cookies()) > 0 {
+			cookies = rec.Result().Cookies()
+		}
+	}
+
+	for _, step := range []struct {
+		path       string
+		wantOps    []Op
+		wantEvents []Event
+		wantSizes  bool
+	}{
+		{
+			path:       "/save",
+			wantOps:    []Op{OpLoad, OpEncode, OpSave},
+			wantEvents: []Event{EventCreated},
+			wantSizes:  true,
+		},
+		{
+			path:    "/",
+			wantOps: []Op{OpLoad, OpDecode},
+		},
+		{
+			path:       "/reset",
+			wantOps:    []Op{OpLoad, OpDecode, OpDelete, OpEncode, OpSave},
+			wantEvents: []Event{EventRotated},
+			wantSizes:  true,
+		},
+		{
+			path:       "/delete",
+			wantOps:    []Op{OpLoad, OpDecode, OpDelete},
+			wantEvents: []Event{EventDeleted},
+		},
+	} {
+		serve(step.path)
+		if !reflect.DeepEqual(obs.ops, step.wantOps) {
+			t.Errorf("%s: want ops %v, got %v", step.path, step.wantOps, obs.ops)
+		}
+		if len(obs.failed) > 0 {
+			t.Errorf("%s: want no failed ops, got %v", step.path, obs.failed)
+		}
+		if !reflect.DeepEqual(obs.events, step.wantEvents) {
+			t.Errorf("%s: want events %v, got %v", step.path, step.wantEvents, obs.events)
+		}
+		if step.wantSizes && (obs.sizes[SizeEncoded] == 0 || obs.sizes[SizeCookie] <= obs.sizes[SizeEncoded]) {
+			t.Errorf("%s: want encoded and larger cookie size recorded, got %v", step.path, obs.sizes)
+		}
+	}
+
+	t.Run("Store context", func(t *testing.T) {
+		obs := &recordingObserver{}
+		kv := &opRecordingKV{KV: NewMemoryKV()}
+		kvStore, err := NewKVStore(kv, &KVStoreOpts{Observer: obs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mgr, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+			MaxLifetime: time.Hour,
+			Observer:    obs,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mgr.Save(r.Context(), mgr.Get(r.Context()))
+		}))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(rec.Result().Cookies()[0])
+		h.ServeHTTP(httptest.NewRecorder(), r)
+
+		if want := []Op{OpSave, OpLoad, OpSave}; !reflect.DeepEqual(kv.ops, want) {
+			t.Errorf("want KV called with ops %v, got %v", want, kv.ops)
+		}
+
+		// a cookie for a session that isn't in the KV
+		obs.reset()
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: DefaultKVStoreCookieOpts.Name, Value: "gone"})
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if !reflect.DeepEqual(obs.events, []Event{EventExpired, EventCreated}) {
+			t.Errorf("want expired and created events, got %v", obs.events)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		obs := &recordingObserver{}
+		mgr, err := NewManager[jsonTestSession](&errStore{getErr: ErrSessionExpired}, &ManagerOpts[*jsonTestSession]{
+			MaxLifetime: time.Hour,
+			Observer:    obs,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+			ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if !reflect.DeepEqual(obs.events, []Event{EventExpired}) {
+			t.Errorf("want expired event, got %v", obs.events)
+		}
+		if !reflect.DeepEqual(obs.failed, []Op{OpLoad}) {
+			t.Errorf("want failed load, got %v", obs.failed)
+		}
+	})
+}
+
+// opRecordingKV records the Observer operation in the context of each call.
+type opRecordingKV struct {
+	KV
+	ops []Op
+}
+
+func (o *opRecordingKV) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	op, _ := ctx.Value(recordingOpKey{}).(Op)
+	o.ops = append(o.ops, op)
+	return o.KV.Get(ctx, key)
+}
+
+func (o *opRecordingKV) Set(ctx context.Context, key string, expiresAt time.Time, value []byte) error {
+	op, _ := ctx.Value(recordingOpKey{}).(Op)
+	o.ops = append(o.ops, op)
+	return o.KV.Set(ctx, key, expiresAt, value)
+}
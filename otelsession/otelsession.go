@@ -0,0 +1,145 @@
+// Package otelsession provides a session.Observer that records OpenTelemetry
+// traces and metrics for session handling.
+//
+// Usage:
+//
+//	obs, err := otelsession.New(nil)
+//	// handle err
+//	mgr, err := session.NewManager[mySession](store, &session.ManagerOpts[*mySession]{
+//		Observer: obs,
+//	})
+package otelsession
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lstoll/session"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/lstoll/session/otelsession"
+
+// Attribute keys set on spans and metrics.
+const (
+	// OperationKey is the session operation, e.g load or save.
+	OperationKey = attribute.Key("session.operation")
+	// EventKey is the session lifecycle event, e.g created or rotated.
+	EventKey = attribute.Key("session.event")
+	// ErrorKey indicates if the operation failed.
+	ErrorKey = attribute.Key("session.error")
+)
+
+var _ session.Observer = (*Observer)(nil)
+
+// Observer records a span and duration metric for each session operation,
+// counts lifecycle events, and records histograms of session sizes.
+type Observer struct {
+	tracer trace.Tracer
+
+	opDuration  metric.Float64Histogram
+	events      metric.Int64Counter
+	encodedSize metric.Int64Histogram
+	cookieSize  metric.Int64Histogram
+}
+
+type Opts struct {
+	// TracerProvider is used to create spans. Defaults to the global
+	// provider.
+	TracerProvider trace.TracerProvider
+	// MeterProvider is used to create metrics. Defaults to the global
+	// provider.
+	MeterProvider metric.MeterProvider
+}
+
+// New creates an Observer.
+func New(opts *Opts) (*Observer, error) {
+	var (
+		tp trace.TracerProvider
+		mp metric.MeterProvider
+	)
+	if opts != nil {
+		tp = opts.TracerProvider
+		mp = opts.MeterProvider
+	}
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+
+	meter := mp.Meter(instrumentationName)
+	o := &Observer{
+		tracer: tp.Tracer(instrumentationName),
+	}
+
+	var err error
+	o.opDuration, err = meter.Float64Histogram("session.operation.duration",
+		metric.WithDescription("Duration of session store and codec operations."),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, fmt.Errorf("creating operation duration histogram: %w", err)
+	}
+	o.events, err = meter.Int64Counter("session.events",
+		metric.WithDescription("Number of session lifecycle events."),
+		metric.WithUnit("{event}"))
+	if err != nil {
+		return nil, fmt.Errorf("creating events counter: %w", err)
+	}
+	o.encodedSize, err = meter.Int64Histogram("session.encoded.size",
+		metric.WithDescription("Size of the encoded session data."),
+		metric.WithUnit("By"))
+	if err != nil {
+		return nil, fmt.Errorf("creating encoded size histogram: %w", err)
+	}
+	o.cookieSize, err = meter.Int64Histogram("session.cookie.size",
+		metric.WithDescription("Total size of the session cookies written."),
+		metric.WithUnit("By"))
+	if err != nil {
+		return nil, fmt.Errorf("creating cookie size histogram: %w", err)
+	}
+
+	return o, nil
+}
+
+// StartOp starts a span for the operation, ending it and recording its
+// duration when the returned function is called. The returned context carries
+// the span, so spans started by the store are its children.
+func (o *Observer) StartOp(ctx context.Context, op session.Op) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := o.tracer.Start(ctx, "session."+string(op),
+		trace.WithAttributes(OperationKey.String(string(op))))
+
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		o.opDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+			OperationKey.String(string(op)),
+			ErrorKey.Bool(err != nil),
+		))
+	}
+}
+
+// SessionEvent increments the events counter.
+func (o *Observer) SessionEvent(ctx context.Context, event session.Event) {
+	o.events.Add(ctx, 1, metric.WithAttributes(EventKey.String(string(event))))
+}
+
+// RecordSize records the size in the histogram for its kind.
+func (o *Observer) RecordSize(ctx context.Context, kind session.SizeKind, size int) {
+	switch kind {
+	case session.SizeEncoded:
+		o.encodedSize.Record(ctx, int64(size))
+	case session.SizeCookie:
+		o.cookieSize.Record(ctx, int64(size))
+	}
+}
@@ -0,0 +1,109 @@
+package otelsession
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lstoll/session"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestObserver(t *testing.T) {
+	ctx := context.Background()
+
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	obs, err := New(&Opts{TracerProvider: tp, MeterProvider: mp})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opCtx, end := obs.StartOp(ctx, session.OpLoad)
+	// a span started by the store, e.g by an instrumented database driver
+	_, storeSpan := tp.Tracer("store").Start(opCtx, "store.get")
+	storeSpan.End()
+	end(nil)
+	_, end = obs.StartOp(ctx, session.OpSave)
+	end(errors.New("boom"))
+	obs.SessionEvent(ctx, session.EventCreated)
+	obs.SessionEvent(ctx, session.EventCreated)
+	obs.SessionEvent(ctx, session.EventRotated)
+	obs.RecordSize(ctx, session.SizeEncoded, 100)
+	obs.RecordSize(ctx, session.SizeCookie, 200)
+
+	ended := spans.Ended()
+	if len(ended) != 3 {
+		t.Fatalf("want 3 spans, got %d", len(ended))
+	}
+	if ended[1].Name() != "session.load" || ended[1].Status().Code == codes.Error {
+		t.Errorf("want successful session.load span, got %s with status %v", ended[1].Name(), ended[1].Status())
+	}
+	if ended[0].Parent().SpanID() != ended[1].SpanContext().SpanID() {
+		t.Errorf("want store span to be a child of the session.load span")
+	}
+	if ended[2].Name() != "session.save" || ended[2].Status().Code != codes.Error {
+		t.Errorf("want failed session.save span, got %s with status %v", ended[2].Name(), ended[2].Status())
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+	metrics := map[string]metricdata.Metrics{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m
+		}
+	}
+
+	durations, ok := metrics["session.operation.duration"].Data.(metricdata.Histogram[float64])
+	if !ok || len(durations.DataPoints) != 2 {
+		t.Errorf("want operation durations for 2 attribute sets, got %v", metrics["session.operation.duration"].Data)
+	}
+
+	events, ok := metrics["session.events"].Data.(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("want events counter, got %v", metrics["session.events"].Data)
+	}
+	gotEvents := map[string]int64{}
+	for _, dp := range events.DataPoints {
+		v, _ := dp.Attributes.Value(EventKey)
+		gotEvents[v.AsString()] = dp.Value
+	}
+	if gotEvents["created"] != 2 || gotEvents["rotated"] != 1 {
+		t.Errorf("want 2 created and 1 rotated events, got %v", gotEvents)
+	}
+
+	for name, want := range map[string]int64{"session.encoded.size": 100, "session.cookie.size": 200} {
+		h, ok := metrics[name].Data.(metricdata.Histogram[int64])
+		if !ok || len(h.DataPoints) != 1 || h.DataPoints[0].Sum != want {
+			t.Errorf("%s: want single recording of %d, got %v", name, want, metrics[name].Data)
+		}
+	}
+
+	var attrs []attribute.KeyValue
+	for _, dp := range durations.DataPoints {
+		attrs = append(attrs, dp.Attributes.ToSlice()...)
+	}
+	if !containsAttr(attrs, ErrorKey.Bool(true)) || !containsAttr(attrs, ErrorKey.Bool(false)) {
+		t.Errorf("want durations split by error, got attributes %v", attrs)
+	}
+}
+
+func containsAttr(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, a := range attrs {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
@@ -4,13 +4,35 @@
 //	CREATE TABLE web_sessions (
 //		id TEXT PRIMARY KEY,
 //		data JSONB NOT NULL, -- if JSON serialized, if proto then bytea
-//		expires_at TIMESTAMPTZ NOT NULL
+//		expires_at TIMESTAMPTZ NOT NULL,
+//		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+//		last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+//		version BIGINT NOT NULL DEFAULT 1,
+//		user_id TEXT,
+//		user_agent TEXT,
+//		ip TEXT
 //	);
 //	CREATE INDEX web_sessions_expires_at_idx ON sessions (expires_at);
+//	CREATE INDEX web_sessions_user_id_idx ON web_sessions (user_id);
 //
 //	COMMENT ON TABLE public.web_sessions IS 'Store for Web/HTTP user sessions';
 //	COMMENT ON COLUMN web_sessions.id IS 'ID of the stored session';
 //	COMMENT ON COLUMN web_sessions.data IS 'Session data, JSON format';
 //	COMMENT ON COLUMN web_sessions.expires_at IS 'When the data should no longer be returned, and is a candidate for garbage collection';
+//	COMMENT ON COLUMN web_sessions.created_at IS 'When the session was first stored';
+//	COMMENT ON COLUMN web_sessions.last_seen_at IS 'When the session was last saved or had its expiry extended';
+//	COMMENT ON COLUMN web_sessions.version IS 'Incremented on every write, for optimistic concurrency control';
+//	COMMENT ON COLUMN web_sessions.user_id IS 'User the session is associated with, if any';
+//	COMMENT ON COLUMN web_sessions.user_agent IS 'User agent of the client the session was associated with the user from';
+//	COMMENT ON COLUMN web_sessions.ip IS 'IP address of the client the session was associated with the user from';
+//
+// Existing tables can add the new columns with:
+//	ALTER TABLE web_sessions ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+//	ALTER TABLE web_sessions ADD COLUMN last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now();
+//	ALTER TABLE web_sessions ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+//	ALTER TABLE web_sessions ADD COLUMN user_id TEXT;
+//	ALTER TABLE web_sessions ADD COLUMN user_agent TEXT;
+//	ALTER TABLE web_sessions ADD COLUMN ip TEXT;
+//	CREATE INDEX web_sessions_user_id_idx ON web_sessions (user_id);
 
 package pgxkv
@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lstoll/session"
 )
 
 const (
@@ -19,57 +22,126 @@ const (
 var (
 	_ DBConn = (*pgx.Conn)(nil)
 	_ DBConn = (*pgxpool.Pool)(nil)
+
+	_ session.KV          = (*KV)(nil)
+	_ session.Toucher     = (*KV)(nil)
+	_ session.UserIndexer = (*KV)(nil)
+	_ session.VersionedKV = (*KV)(nil)
+	_ session.Locker      = (*KV)(nil)
+	_ session.LockChecker = (*KV)(nil)
+
+	_ Acquirer = (*pgxpool.Pool)(nil)
 )
 
 type DBConn interface {
 	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, optionsAndArgs ...interface{}) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, optionsAndArgs ...interface{}) pgx.Row
 }
 
 const (
-	getQueryTemplate    = `SELECT data FROM %s WHERE id = $1 AND expires_at > now()`
-	setQueryTemplate    = `INSERT INTO %s (id, data, expires_at) VALUES ($1, $2, $3) ON CONFLICT(id) DO UPDATE SET data=EXCLUDED.data, expires_at=EXCLUDED.expires_at`
+	getQueryTemplate    = `SELECT data, version FROM %s WHERE id = $1 AND expires_at > now()`
+	setQueryTemplate    = `INSERT INTO %[1]s (id, data, expires_at) VALUES ($1, $2, $3) ON CONFLICT(id) DO UPDATE SET data=EXCLUDED.data, expires_at=EXCLUDED.expires_at, last_seen_at=now(), version=%[1]s.version+1`
+	touchQueryTemplate  = `UPDATE %s SET expires_at = $2, last_seen_at = now() WHERE id = $1 AND expires_at > now()`
 	deleteQueryTemplate = `DELETE FROM %s WHERE id = $1`
 	gcQueryTemplate     = `DELETE FROM %s WHERE expires_at < now()`
+
+	setUserQueryTemplate    = `UPDATE %s SET user_id = $2, user_agent = $3, ip = $4 WHERE id = $1 AND expires_at > now()`
+	listUserQueryTemplate   = `SELECT id, created_at, last_seen_at, COALESCE(user_agent, ''), COALESCE(ip, '') FROM %s WHERE user_id = $1 AND expires_at > now() ORDER BY created_at`
+	deleteUserQueryTemplate = `DELETE FROM %s WHERE user_id = $1`
+
+	// createQueryTemplate inserts an item if it does not exist, or replaces it
+	// if it has expired.
+	createQueryTemplate = `INSERT INTO %[1]s (id, data, expires_at) VALUES ($1, $2, $3) ON CONFLICT(id) DO UPDATE SET data=EXCLUDED.data, expires_at=EXCLUDED.expires_at, created_at=now(), last_seen_at=now(), version=1, user_id=NULL, user_agent=NULL, ip=NULL WHERE %[1]s.expires_at <= now()`
+	updateQueryTemplate = `UPDATE %s SET data = $3, expires_at = $4, last_seen_at = now(), version = version + 1 WHERE id = $1 AND version = $2 AND expires_at > now()`
+
+	lockQuery   = `SELECT pg_try_advisory_lock(hashtextextended($1, 0))`
+	unlockQuery = `SELECT pg_advisory_unlock(hashtextextended($1, 0))`
 )
 
+const (
+	// lockMinBackoff and lockMaxBackoff bound the wait between attempts to take
+	// a held lock.
+	lockMinBackoff = 10 * time.Millisecond
+	lockMaxBackoff = 250 * time.Millisecond
+)
+
+// Acquirer is implemented by connection pools that can dedicate a connection
+// to a caller, such as *pgxpool.Pool. It is required for locking.
+type Acquirer interface {
+	Acquire(ctx context.Context) (*pgxpool.Conn, error)
+}
+
 type KV struct {
-	conn DBConn
+	conn      DBConn
+	tableName string
+	logger    *slog.Logger
 
 	getQuery    string
 	setQuery    string
+	touchQuery  string
 	deleteQuery string
 	gcQuery     string
+
+	setUserQuery    string
+	listUserQuery   string
+	deleteUserQuery string
+
+	createQuery string
+	updateQuery string
 }
 
 type Opts struct {
 	TableName string
+	// Logger is used to log background operations and lock release failures.
+	// If nil, nothing is logged.
+	Logger *slog.Logger
 }
 
 func New(conn DBConn, opts *Opts) *KV {
 	tn := DefaultTableName
-	if opts != nil && opts.TableName != "" {
-		tn = opts.TableName
+	var logger *slog.Logger
+	if opts != nil {
+		if opts.TableName != "" {
+			tn = opts.TableName
+		}
+		logger = opts.Logger
 	}
 	return &KV{
-		conn: conn,
+		conn:      conn,
+		tableName: tn,
+		logger:    logger,
 
 		getQuery:    fmt.Sprintf(getQueryTemplate, tn),
 		setQuery:    fmt.Sprintf(setQueryTemplate, tn),
+		touchQuery:  fmt.Sprintf(touchQueryTemplate, tn),
 		deleteQuery: fmt.Sprintf(deleteQueryTemplate, tn),
 		gcQuery:     fmt.Sprintf(gcQueryTemplate, tn),
+
+		setUserQuery:    fmt.Sprintf(setUserQueryTemplate, tn),
+		listUserQuery:   fmt.Sprintf(listUserQueryTemplate, tn),
+		deleteUserQuery: fmt.Sprintf(deleteUserQueryTemplate, tn),
+
+		createQuery: fmt.Sprintf(createQueryTemplate, tn),
+		updateQuery: fmt.Sprintf(updateQueryTemplate, tn),
 	}
 }
 
 func (k *KV) Get(ctx context.Context, key string) (_ []byte, found bool, _ error) {
+	data, _, found, err := k.GetVersioned(ctx, key)
+	return data, found, err
+}
+
+// GetVersioned returns the data for key, along with its version.
+func (k *KV) GetVersioned(ctx context.Context, key string) (_ []byte, version int64, found bool, _ error) {
 	var data []byte
-	if err := k.conn.QueryRow(ctx, k.getQuery, key).Scan(&data); err != nil {
+	if err := k.conn.QueryRow(ctx, k.getQuery, key).Scan(&data, &version); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, false, nil
+			return nil, 0, false, nil
 		}
-		return nil, false, fmt.Errorf("getting %s: %w", key, err)
+		return nil, 0, false, fmt.Errorf("getting %s: %w", key, err)
 	}
-	return data, true, nil
+	return data, version, true, nil
 }
 
 func (k *KV) Set(ctx context.Context, key string, expiresAt time.Time, value []byte) error {
@@ -79,6 +151,116 @@ func (k *KV) Set(ctx context.Context, key string, expiresAt time.Time, value []b
 	return nil
 }
 
+// CompareAndSet sets the data for key, if its current version matches version.
+// A version of 0 creates the key if it does not exist. session.ErrConflict is
+// returned if the version does not match.
+func (k *KV) CompareAndSet(ctx context.Context, key string, version int64, expiresAt time.Time, value []byte) error {
+	var (
+		res pgconn.CommandTag
+		err error
+	)
+	if version == 0 {
+		res, err = k.conn.Exec(ctx, k.createQuery, key, value, expiresAt)
+	} else {
+		res, err = k.conn.Exec(ctx, k.updateQuery, key, version, value, expiresAt)
+	}
+	if err != nil {
+		return fmt.Errorf("setting %s: %w", key, err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("setting %s at version %d: %w", key, version, session.ErrConflict)
+	}
+	return nil
+}
+
+// CheckLockable returns an error if the KV was not created with a connection
+// pool implementing Acquirer, which Lock requires.
+func (k *KV) CheckLockable() error {
+	if _, ok := k.conn.(Acquirer); !ok {
+		return fmt.Errorf("locking requires a connection pool, got %T", k.conn)
+	}
+	return nil
+}
+
+// Lock acquires a postgres session-level advisory lock for key, on a
+// connection dedicated to the lock until it is released. The KV must have been
+// created with a connection pool implementing Acquirer. While the lock is held
+// by another caller it is retried with backoff, and the connection is returned
+// to the pool between attempts so waiters don't exhaust it. The lock is
+// released if the connection is lost.
+func (k *KV) Lock(ctx context.Context, key string) (unlock func(), _ error) {
+	if err := k.CheckLockable(); err != nil {
+		return nil, err
+	}
+	acq := k.conn.(Acquirer)
+
+	// namespace the lock to the table, to avoid colliding with other users of
+	// advisory locks.
+	lockID := k.tableName + ":" + key
+
+	var conn *pgxpool.Conn
+	for backoff := lockMinBackoff; ; backoff = min(backoff*2, lockMaxBackoff) {
+		c, err := acq.Acquire(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("acquiring connection for lock: %w", err)
+		}
+		var locked bool
+		if err := c.QueryRow(ctx, lockQuery, lockID).Scan(&locked); err != nil {
+			// the query may have been interrupted, in which case the
+			// connection state is unknown. Close it, which also releases any
+			// lock it obtained.
+			closeConn(c)
+			return nil, fmt.Errorf("locking %s: %w", key, err)
+		}
+		if locked {
+			conn = c
+			break
+		}
+		c.Release()
+
+		// jitter the wait, so waiters don't retry in lockstep.
+		t := time.NewTimer(backoff/2 + rand.N(backoff/2))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, fmt.Errorf("locking %s: %w", key, ctx.Err())
+		case <-t.C:
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if _, err := conn.Exec(ctx, unlockQuery, lockID); err != nil {
+				if k.logger != nil {
+					k.logger.WarnContext(ctx, "Releasing session lock failed, closing connection", "error", err)
+				}
+				closeConn(conn)
+				return
+			}
+			conn.Release()
+		})
+	}, nil
+}
+
+// closeConn closes the pool connection rather than returning it to the pool.
+func closeConn(conn *pgxpool.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = conn.Conn().Close(ctx)
+	conn.Release()
+}
+
+// Touch updates the expiry of key, without rewriting the data.
+func (k *KV) Touch(ctx context.Context, key string, expiresAt time.Time) error {
+	if _, err := k.conn.Exec(ctx, k.touchQuery, key, expiresAt); err != nil {
+		return fmt.Errorf("touching %s: %w", key, err)
+	}
+	return nil
+}
+
 func (k *KV) Delete(ctx context.Context, key string) error {
 	if _, err := k.conn.Exec(ctx, k.deleteQuery, key); err != nil {
 		return fmt.Errorf("deleting %s: %w", key, err)
@@ -87,6 +269,40 @@ func (k *KV) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// SetUser associates key with userID and the device it was used from.
+func (k *KV) SetUser(ctx context.Context, key, userID string, device session.DeviceInfo) error {
+	if _, err := k.conn.Exec(ctx, k.setUserQuery, key, userID, device.UserAgent, device.IP); err != nil {
+		return fmt.Errorf("setting user for %s: %w", key, err)
+	}
+	return nil
+}
+
+// ListUser returns the unexpired items associated with userID, oldest first.
+func (k *KV) ListUser(ctx context.Context, userID string) ([]session.UserSession, error) {
+	rows, err := k.conn.Query(ctx, k.listUserQuery, userID)
+	if err != nil {
+		return nil, fmt.Errorf("listing items for user: %w", err)
+	}
+	sessions, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (session.UserSession, error) {
+		var s session.UserSession
+		err := row.Scan(&s.ID, &s.CreatedAt, &s.LastSeenAt, &s.Device.UserAgent, &s.Device.IP)
+		return s, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing items for user: %w", err)
+	}
+	return sessions, nil
+}
+
+// DeleteUser deletes all the items associated with userID.
+func (k *KV) DeleteUser(ctx context.Context, userID string) (deleted int, _ error) {
+	res, err := k.conn.Exec(ctx, k.deleteUserQuery, userID)
+	if err != nil {
+		return 0, fmt.Errorf("deleting items for user: %w", err)
+	}
+	return int(res.RowsAffected()), nil
+}
+
 func (k *KV) GC(ctx context.Context) (deleted int, _ error) {
 	res, err := k.conn.Exec(ctx, k.gcQuery)
 	if err != nil {
@@ -95,7 +311,12 @@ func (k *KV) GC(ctx context.Context) (deleted int, _ error) {
 	return int(res.RowsAffected()), nil
 }
 
+// RunGC starts a goroutine that calls GC every interval, until ctx is
+// canceled. Results are logged to logger, or the KV's Logger if it is nil.
 func (k *KV) RunGC(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	if logger == nil {
+		logger = k.logger
+	}
 	go func() {
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
@@ -3,19 +3,28 @@ package pgxkv
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"os"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lstoll/session"
 )
 
 const createTable = `CREATE TABLE IF NOT EXISTS web_sessions (
 	id TEXT PRIMARY KEY,
 	data JSONB NOT NULL, -- if JSON serialized, if proto then bytea
 	expires_at TIMESTAMPTZ NOT NULL
-);`
+);
+ALTER TABLE web_sessions ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE web_sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE web_sessions ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE web_sessions ADD COLUMN IF NOT EXISTS user_id TEXT;
+ALTER TABLE web_sessions ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE web_sessions ADD COLUMN IF NOT EXISTS ip TEXT;`
 
 func clearTable(t *testing.T, conn DBConn) {
 	if _, err := conn.Exec(context.Background(), `DELETE FROM web_sessions`); err != nil {
@@ -171,6 +180,268 @@ func TestKV_E2E(t *testing.T) {
 		assertJSONeq(t, value2, retrievedValue)
 	})
 
+	t.Run("E2E_Touch", func(t *testing.T) {
+		clearTable(t, conn)
+
+		key := "testkey_touch"
+		value := []byte(`{"value":1}`)
+
+		// Set with a short expiry
+		err := kv.Set(ctx, key, time.Now().Add(time.Second), value)
+		if err != nil {
+			t.Fatalf("Set() error = %v, wantErr %v", err, nil)
+		}
+
+		// Touch to extend it
+		if err := kv.Touch(ctx, key, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Touch() error = %v, wantErr %v", err, nil)
+		}
+
+		var expiresAt time.Time
+		if err := conn.QueryRow(ctx, `SELECT expires_at FROM web_sessions WHERE id = $1`, key).Scan(&expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		if time.Until(expiresAt) < 59*time.Minute {
+			t.Errorf("want expiry extended, got %s", expiresAt)
+		}
+
+		retrievedValue, found, err := kv.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get() error = %v, wantErr %v", err, nil)
+		}
+		if !found {
+			t.Fatalf("Get() found = %v, want %v", found, true)
+		}
+		assertJSONeq(t, value, retrievedValue)
+
+		// Touching a missing key is a no-op
+		if err := kv.Touch(ctx, "missing", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Touch() error = %v, wantErr %v", err, nil)
+		}
+		if _, found, _ := kv.Get(ctx, "missing"); found {
+			t.Error("Touch() should not create missing key")
+		}
+	})
+
+	t.Run("E2E_DeleteUser", func(t *testing.T) {
+		clearTable(t, conn)
+
+		expiresAt := time.Now().Add(time.Hour)
+		for _, key := range []string{"user1_a", "user1_b", "user2_a"} {
+			if err := kv.Set(ctx, key, expiresAt, []byte(`{"value":1}`)); err != nil {
+				t.Fatalf("Set() error = %v, wantErr %v", err, nil)
+			}
+		}
+		for key, user := range map[string]string{"user1_a": "user1", "user1_b": "user1", "user2_a": "user2"} {
+			if err := kv.SetUser(ctx, key, user, session.DeviceInfo{}); err != nil {
+				t.Fatalf("SetUser() error = %v, wantErr %v", err, nil)
+			}
+		}
+
+		// updating the value retains the user
+		if err := kv.Set(ctx, "user1_b", expiresAt, []byte(`{"value":2}`)); err != nil {
+			t.Fatalf("Set() error = %v, wantErr %v", err, nil)
+		}
+
+		deleted, err := kv.DeleteUser(ctx, "user1")
+		if err != nil {
+			t.Fatalf("DeleteUser() error = %v, wantErr %v", err, nil)
+		}
+		if deleted != 2 {
+			t.Errorf("DeleteUser() deleted = %v, want %v", deleted, 2)
+		}
+
+		for key, want := range map[string]bool{"user1_a": false, "user1_b": false, "user2_a": true} {
+			_, found, err := kv.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("Get() error = %v, wantErr %v", err, nil)
+			}
+			if found != want {
+				t.Errorf("Get(%s) found = %v, want %v", key, found, want)
+			}
+		}
+	})
+
+	t.Run("E2E_ListUser", func(t *testing.T) {
+		clearTable(t, conn)
+
+		expiresAt := time.Now().Add(time.Hour)
+		for _, key := range []string{"user1_a", "user1_b", "user1_expired"} {
+			if err := kv.Set(ctx, key, expiresAt, []byte(`{"value":1}`)); err != nil {
+				t.Fatalf("Set() error = %v, wantErr %v", err, nil)
+			}
+		}
+		device := session.DeviceInfo{UserAgent: "test-agent", IP: "192.0.2.1"}
+		for _, key := range []string{"user1_a", "user1_b", "user1_expired"} {
+			if err := kv.SetUser(ctx, key, "user1", device); err != nil {
+				t.Fatalf("SetUser() error = %v, wantErr %v", err, nil)
+			}
+		}
+		if err := kv.Set(ctx, "user1_expired", time.Now().Add(-time.Second), []byte(`{"value":1}`)); err != nil {
+			t.Fatalf("Set() error = %v, wantErr %v", err, nil)
+		}
+
+		got, err := kv.ListUser(ctx, "user1")
+		if err != nil {
+			t.Fatalf("ListUser() error = %v, wantErr %v", err, nil)
+		}
+		if len(got) != 2 {
+			t.Fatalf("ListUser() returned %d items, want %d", len(got), 2)
+		}
+		for _, s := range got {
+			if s.ID != "user1_a" && s.ID != "user1_b" {
+				t.Errorf("ListUser() returned unexpected item %s", s.ID)
+			}
+			if s.Device != device {
+				t.Errorf("ListUser() device = %v, want %v", s.Device, device)
+			}
+			if s.CreatedAt.IsZero() || s.LastSeenAt.IsZero() {
+				t.Errorf("ListUser() want timestamps set, got %v", s)
+			}
+		}
+	})
+
+	t.Run("E2E_CompareAndSet", func(t *testing.T) {
+		clearTable(t, conn)
+
+		key := "testkey_cas"
+		expiresAt := time.Now().Add(time.Hour)
+
+		// create
+		if err := kv.CompareAndSet(ctx, key, 0, expiresAt, []byte(`{"value":1}`)); err != nil {
+			t.Fatalf("CompareAndSet() error = %v, wantErr %v", err, nil)
+		}
+		if err := kv.CompareAndSet(ctx, key, 0, expiresAt, []byte(`{"value":1}`)); !errors.Is(err, session.ErrConflict) {
+			t.Fatalf("CompareAndSet() of existing key error = %v, want %v", err, session.ErrConflict)
+		}
+
+		value, version, found, err := kv.GetVersioned(ctx, key)
+		if err != nil {
+			t.Fatalf("GetVersioned() error = %v, wantErr %v", err, nil)
+		}
+		if !found || version != 1 {
+			t.Fatalf("GetVersioned() found = %v, version = %d, want true, 1", found, version)
+		}
+		assertJSONeq(t, []byte(`{"value":1}`), value)
+
+		// a blind write invalidates the version
+		if err := kv.Set(ctx, key, expiresAt, []byte(`{"value":2}`)); err != nil {
+			t.Fatalf("Set() error = %v, wantErr %v", err, nil)
+		}
+		if err := kv.CompareAndSet(ctx, key, version, expiresAt, []byte(`{"value":3}`)); !errors.Is(err, session.ErrConflict) {
+			t.Fatalf("CompareAndSet() with stale version error = %v, want %v", err, session.ErrConflict)
+		}
+		if err := kv.CompareAndSet(ctx, key, version+1, expiresAt, []byte(`{"value":3}`)); err != nil {
+			t.Fatalf("CompareAndSet() error = %v, wantErr %v", err, nil)
+		}
+
+		value, version, _, err = kv.GetVersioned(ctx, key)
+		if err != nil {
+			t.Fatalf("GetVersioned() error = %v, wantErr %v", err, nil)
+		}
+		if version != 3 {
+			t.Errorf("GetVersioned() version = %d, want %d", version, 3)
+		}
+		assertJSONeq(t, []byte(`{"value":3}`), value)
+	})
+
+	t.Run("E2E_Lock", func(t *testing.T) {
+		pool, err := pgxpool.New(ctx, dburl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(pool.Close)
+		poolKV := New(pool, nil)
+
+		unlock, err := poolKV.Lock(ctx, "lockkey")
+		if err != nil {
+			t.Fatalf("Lock() error = %v, wantErr %v", err, nil)
+		}
+
+		// a second lock on the same key waits
+		tctx, tcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer tcancel()
+		if _, err := poolKV.Lock(tctx, "lockkey"); err == nil {
+			t.Fatal("Lock() of held key should time out")
+		}
+
+		// other keys are independent
+		unlockOther, err := poolKV.Lock(ctx, "otherkey")
+		if err != nil {
+			t.Fatalf("Lock() error = %v, wantErr %v", err, nil)
+		}
+		unlockOther()
+
+		unlock()
+		unlock, err = poolKV.Lock(ctx, "lockkey")
+		if err != nil {
+			t.Fatalf("Lock() after unlock error = %v, wantErr %v", err, nil)
+		}
+		unlock()
+
+		// a single connection can't be dedicated to the lock
+		if _, err := kv.Lock(ctx, "lockkey"); err == nil {
+			t.Error("Lock() without a pool should fail")
+		}
+	})
+
+	t.Run("E2E_LockContention", func(t *testing.T) {
+		cfg, err := pgxpool.ParseConfig(dburl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.MaxConns = 2
+		pool, err := pgxpool.NewWithConfig(ctx, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(pool.Close)
+		poolKV := New(pool, nil)
+
+		unlock, err := poolKV.Lock(ctx, "contendedkey")
+		if err != nil {
+			t.Fatalf("Lock() error = %v, wantErr %v", err, nil)
+		}
+
+		// more waiters than connections
+		const waiters = 5
+		errc := make(chan error, waiters)
+		for range waiters {
+			go func() {
+				wctx, wcancel := context.WithTimeout(ctx, 30*time.Second)
+				defer wcancel()
+				unlock, err := poolKV.Lock(wctx, "contendedkey")
+				if err != nil {
+					errc <- err
+					return
+				}
+				unlock()
+				errc <- nil
+			}()
+		}
+
+		// the holder can still use the pool while they wait
+		for i := range 10 {
+			qctx, qcancel := context.WithTimeout(ctx, 5*time.Second)
+			err := poolKV.Set(qctx, "contendedkey", time.Now().Add(time.Hour), []byte(`{"value":1}`))
+			if err == nil {
+				_, _, err = poolKV.Get(qctx, "contendedkey")
+			}
+			qcancel()
+			if err != nil {
+				t.Fatalf("query %d while waiters blocked: %v", i, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		unlock()
+		for range waiters {
+			if err := <-errc; err != nil {
+				t.Errorf("waiter Lock() error = %v, wantErr %v", err, nil)
+			}
+		}
+	})
+
 	t.Run("E2E_GetExpiredKey_Not_GCd", func(t *testing.T) {
 		clearTable(t, conn)
 
@@ -213,3 +484,29 @@ func assertJSONeq(t testing.TB, want, got []byte) {
 		t.Errorf("want %s, got: %s", want, got)
 	}
 }
+
+func TestCheckLockable(t *testing.T) {
+	// the pool connects lazily, so no database is needed.
+	pool, err := pgxpool.New(context.Background(), "postgres://localhost/unused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	if err := New(pool, nil).CheckLockable(); err != nil {
+		t.Errorf("CheckLockable() with a pool error = %v, wantErr %v", err, nil)
+	}
+
+	// a single connection can't be dedicated to the lock, so the manager
+	// should refuse to lock sessions with it.
+	kvStore, err := session.NewKVStore(New((*pgx.Conn)(nil), nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.NewManager[map[string]string](kvStore, &session.ManagerOpts[*map[string]string]{
+		IdleTimeout:  time.Hour,
+		LockSessions: true,
+	}); err == nil {
+		t.Error("NewManager() with LockSessions on a single connection should fail")
+	}
+}
@@ -0,0 +1,7 @@
+// Package rediskv provides a Redis-backed session store, using the go-redis
+// client.
+//
+// Items are stored as plain string values under a configurable key prefix,
+// with their expiry set using SET's PXAT option so Redis removes them when they
+// expire. No garbage collection is required.
+package rediskv
@@ -0,0 +1,16 @@
+module github.com/lstoll/session/rediskv
+
+go 1.22.0
+
+require (
+	github.com/alicebob/miniredis/v2 v2.37.0
+	github.com/lstoll/session v0.1.0
+	github.com/redis/go-redis/v9 v9.17.2
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	google.golang.org/protobuf v1.36.4 // indirect
+)
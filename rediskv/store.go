@@ -0,0 +1,148 @@
+package rediskv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lstoll/session"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultKeyPrefix is prepended to keys if no prefix is configured.
+	DefaultKeyPrefix = "session:"
+
+	// scanCount is the number of keys requested per SCAN when deleting by
+	// prefix.
+	scanCount = 100
+)
+
+var (
+	_ Client = (*redis.Client)(nil)
+	_ Client = (*redis.ClusterClient)(nil)
+	_ Client = (redis.UniversalClient)(nil)
+
+	_ session.KV      = (*KV)(nil)
+	_ session.Toucher = (*KV)(nil)
+)
+
+// Client is the subset of the go-redis client used by the KV.
+type Client interface {
+	Do(ctx context.Context, args ...interface{}) *redis.Cmd
+	Get(ctx context.Context, key string) *redis.StringCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	PExpireAt(ctx context.Context, key string, tm time.Time) *redis.BoolCmd
+	Persist(ctx context.Context, key string) *redis.BoolCmd
+	Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd
+}
+
+type KV struct {
+	client Client
+	prefix string
+}
+
+type Opts struct {
+	// KeyPrefix is prepended to all keys, to namespace them in the database.
+	// Defaults to DefaultKeyPrefix.
+	KeyPrefix string
+}
+
+func New(client Client, opts *Opts) *KV {
+	prefix := DefaultKeyPrefix
+	if opts != nil && opts.KeyPrefix != "" {
+		prefix = opts.KeyPrefix
+	}
+	return &KV{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (k *KV) Get(ctx context.Context, key string) (_ []byte, found bool, _ error) {
+	b, err := k.client.Get(ctx, k.prefix+key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("getting %s: %w", key, err)
+	}
+	return b, true, nil
+}
+
+func (k *KV) Set(ctx context.Context, key string, expiresAt time.Time, value []byte) error {
+	args := []any{"SET", k.prefix + key, value}
+	if !expiresAt.IsZero() {
+		args = append(args, "PXAT", expiresAt.UnixMilli())
+	}
+	if err := k.client.Do(ctx, args...).Err(); err != nil {
+		return fmt.Errorf("setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// Touch updates the expiry of key, without rewriting the data. A zero
+// expiresAt removes the expiry, like Set.
+func (k *KV) Touch(ctx context.Context, key string, expiresAt time.Time) error {
+	var err error
+	if expiresAt.IsZero() {
+		// a time in the past would delete the key.
+		err = k.client.Persist(ctx, k.prefix+key).Err()
+	} else {
+		err = k.client.PExpireAt(ctx, k.prefix+key, expiresAt).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("touching %s: %w", key, err)
+	}
+	return nil
+}
+
+func (k *KV) Delete(ctx context.Context, key string) error {
+	if err := k.client.Del(ctx, k.prefix+key).Err(); err != nil {
+		return fmt.Errorf("deleting %s: %w", key, err)
+	}
+	return nil
+}
+
+// DeletePrefix deletes all the items with keys starting with prefix. An empty
+// prefix deletes all items under the KV's key prefix, e.g to log out all
+// users. A session.KVStore stores sessions under a hash of their ID, so for
+// its items only the empty prefix is useful. It uses SCAN, so with a cluster
+// client only the node the command is routed to is scanned.
+func (k *KV) DeletePrefix(ctx context.Context, prefix string) (deleted int, _ error) {
+	match := escapeGlob(k.prefix+prefix) + "*"
+
+	var cursor uint64
+	for {
+		keys, next, err := k.client.Scan(ctx, cursor, match, scanCount).Result()
+		if err != nil {
+			return deleted, fmt.Errorf("scanning %s: %w", prefix, err)
+		}
+		if len(keys) > 0 {
+			n, err := k.client.Del(ctx, keys...).Result()
+			if err != nil {
+				return deleted, fmt.Errorf("deleting %s: %w", prefix, err)
+			}
+			deleted += int(n)
+		}
+		if next == 0 {
+			return deleted, nil
+		}
+		cursor = next
+	}
+}
+
+// escapeGlob escapes the special characters in a redis glob pattern.
+func escapeGlob(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\', '^', '-':
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,147 @@
+package rediskv
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/lstoll/session"
+	"github.com/lstoll/session/kvtest"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestClient returns a client for the server at REDISKV_TEST_ADDR if it is
+// set, e.g a local redis-server, otherwise for an in-process miniredis.
+func newTestClient(t *testing.T) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("REDISKV_TEST_ADDR")
+	if addr == "" {
+		addr = miniredis.RunT(t).Addr()
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestKV(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	// use a unique prefix, so a shared server's data isn't touched.
+	kv := New(client, &Opts{KeyPrefix: "rediskv-test-" + t.Name() + ":"})
+
+	t.Run("Expiry", func(t *testing.T) {
+		if err := kv.Set(ctx, "expired", time.Now().Add(-time.Minute), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := kv.Get(ctx, "expired"); err != nil || ok {
+			t.Errorf("want expired key not found, got found %t err %v", ok, err)
+		}
+
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+		if err := kv.Set(ctx, "ttl", expiresAt, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		got, err := client.PExpireTime(ctx, kv.prefix+"ttl").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Milliseconds() != expiresAt.UnixMilli() {
+			t.Errorf("want expiry %d, got %d", expiresAt.UnixMilli(), got.Milliseconds())
+		}
+	})
+
+	t.Run("Touch", func(t *testing.T) {
+		if err := kv.Set(ctx, "touched", time.Now().Add(time.Minute), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+		if err := kv.Touch(ctx, "touched", expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		got, err := client.PExpireTime(ctx, kv.prefix+"touched").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Milliseconds() != expiresAt.UnixMilli() {
+			t.Errorf("want expiry %d, got %d", expiresAt.UnixMilli(), got.Milliseconds())
+		}
+
+		// a zero expiry removes it, rather than deleting the key
+		if err := kv.Touch(ctx, "touched", time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := kv.Get(ctx, "touched"); err != nil || !ok {
+			t.Fatalf("want key touched with zero expiry found, got found %t err %v", ok, err)
+		}
+		ttl, err := client.PTTL(ctx, kv.prefix+"touched").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl != -1 {
+			t.Errorf("want no expiry, got ttl %s", ttl)
+		}
+
+		// touching a missing key should not create it
+		if err := kv.Touch(ctx, "missing", expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := kv.Get(ctx, "missing"); err != nil || ok {
+			t.Errorf("want touched missing key not found, got found %t err %v", ok, err)
+		}
+	})
+
+	t.Run("DeletePrefix", func(t *testing.T) {
+		// separate namespace, so only keys from this test are deleted
+		kv := New(client, &Opts{KeyPrefix: "rediskv-test-" + t.Name() + ":"})
+
+		for _, key := range []string{"user1:a", "user1:b", "user1*", "user2:a"} {
+			if err := kv.Set(ctx, key, time.Now().Add(time.Hour), []byte("value")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, tc := range []struct {
+			prefix      string
+			wantDeleted int
+			wantKept    []string
+		}{
+			{
+				prefix:      "user1*",
+				wantDeleted: 1,
+				wantKept:    []string{"user1:a", "user1:b", "user2:a"},
+			},
+			{
+				prefix:      "user1:",
+				wantDeleted: 2,
+				wantKept:    []string{"user2:a"},
+			},
+			{
+				prefix:      "",
+				wantDeleted: 1,
+			},
+		} {
+			n, err := kv.DeletePrefix(ctx, tc.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tc.wantDeleted {
+				t.Errorf("prefix %q: want %d deleted, got %d", tc.prefix, tc.wantDeleted, n)
+			}
+			for _, key := range tc.wantKept {
+				if _, ok, err := kv.Get(ctx, key); err != nil || !ok {
+					t.Errorf("prefix %q: want %s kept, got found %t err %v", tc.prefix, key, ok, err)
+				}
+			}
+		}
+	})
+}
+
+func TestKVConformance(t *testing.T) {
+	client := newTestClient(t)
+	kvtest.TestKV(t, func(t *testing.T) session.KV {
+		// a prefix per test, so each starts empty.
+		return New(client, &Opts{KeyPrefix: "rediskv-test-" + t.Name() + ":"})
+	})
+}
@@ -0,0 +1,115 @@
+package sqlkv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect selects the SQL syntax and column types used for a database.
+type Dialect int
+
+const (
+	// DialectSQLite is used for SQLite. Timestamps are stored as unix
+	// milliseconds.
+	DialectSQLite Dialect = iota + 1
+	// DialectMySQL is used for MySQL and MariaDB. Timestamps are stored as UTC
+	// DATETIMEs.
+	DialectMySQL
+	// DialectPostgres is used for Postgres.
+	DialectPostgres
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case DialectSQLite:
+		return "sqlite"
+	case DialectMySQL:
+		return "mysql"
+	case DialectPostgres:
+		return "postgres"
+	default:
+		return "Dialect(" + strconv.Itoa(int(d)) + ")"
+	}
+}
+
+func (d Dialect) valid() bool {
+	return d >= DialectSQLite && d <= DialectPostgres
+}
+
+// Queries are written with ? placeholders, and rebound for the dialect.
+const (
+	getQueryTemplate    = `SELECT data FROM %s WHERE id = ? AND expires_at > ?`
+	touchQueryTemplate  = `UPDATE %s SET expires_at = ? WHERE id = ? AND expires_at > ?`
+	deleteQueryTemplate = `DELETE FROM %s WHERE id = ?`
+	gcQueryTemplate     = `DELETE FROM %s WHERE expires_at < ?`
+
+	upsertQueryTemplate      = `INSERT INTO %s (id, data, expires_at) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET data = excluded.data, expires_at = excluded.expires_at`
+	mysqlUpsertQueryTemplate = `INSERT INTO %s (id, data, expires_at) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE data = VALUES(data), expires_at = VALUES(expires_at)`
+)
+
+// setQueryTemplate returns the upsert query template for the dialect.
+func (d Dialect) setQueryTemplate() string {
+	if d == DialectMySQL {
+		return mysqlUpsertQueryTemplate
+	}
+	return upsertQueryTemplate
+}
+
+// query formats the template with the table name, and rebinds the
+// placeholders for the dialect.
+func (d Dialect) query(template, tableName string) string {
+	q := fmt.Sprintf(template, tableName)
+	if d != DialectPostgres {
+		return q
+	}
+	var (
+		sb strings.Builder
+		n  int
+	)
+	for _, r := range q {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// schema returns the statements to create the table, if it does not exist.
+func (d Dialect) schema(tableName string) []string {
+	index := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %[1]s_expires_at_idx ON %[1]s (expires_at)`, tableName)
+	switch d {
+	case DialectSQLite:
+		return []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (id TEXT PRIMARY KEY, data BLOB NOT NULL, expires_at INTEGER NOT NULL)`, tableName),
+			index,
+		}
+	case DialectMySQL:
+		// MySQL does not support CREATE INDEX IF NOT EXISTS, so the index is
+		// created with the table.
+		return []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (id VARCHAR(255) PRIMARY KEY, data LONGBLOB NOT NULL, expires_at DATETIME(6) NOT NULL, INDEX %[1]s_expires_at_idx (expires_at))`, tableName),
+		}
+	default:
+		return []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (id TEXT PRIMARY KEY, data BYTEA NOT NULL, expires_at TIMESTAMPTZ NOT NULL)`, tableName),
+			index,
+		}
+	}
+}
+
+// timeArg converts t to the value stored in the expires_at column.
+func (d Dialect) timeArg(t time.Time) any {
+	switch d {
+	case DialectSQLite:
+		return t.UnixMilli()
+	case DialectMySQL:
+		return t.UTC()
+	default:
+		return t
+	}
+}
@@ -0,0 +1,39 @@
+// Package sqlkv provides a session store on top of database/sql. It supports
+// SQLite, MySQL and Postgres, and is used with the database's driver, e.g:
+//
+//	db, err := sql.Open("sqlite3", "sessions.db")
+//	kv, err := sqlkv.New(db, sqlkv.DialectSQLite, nil)
+//	err = kv.CreateTable(ctx)
+//
+// CreateTable creates the table if it does not exist. The schemas used are:
+//
+// SQLite:
+//
+//	CREATE TABLE IF NOT EXISTS web_sessions (
+//		id TEXT PRIMARY KEY,
+//		data BLOB NOT NULL,
+//		expires_at INTEGER NOT NULL -- unix milliseconds
+//	);
+//	CREATE INDEX IF NOT EXISTS web_sessions_expires_at_idx ON web_sessions (expires_at);
+//
+// MySQL:
+//
+//	CREATE TABLE IF NOT EXISTS web_sessions (
+//		id VARCHAR(255) PRIMARY KEY,
+//		data LONGBLOB NOT NULL,
+//		expires_at DATETIME(6) NOT NULL, -- UTC
+//		INDEX web_sessions_expires_at_idx (expires_at)
+//	);
+//
+// Postgres:
+//
+//	CREATE TABLE IF NOT EXISTS web_sessions (
+//		id TEXT PRIMARY KEY,
+//		data BYTEA NOT NULL,
+//		expires_at TIMESTAMPTZ NOT NULL
+//	);
+//	CREATE INDEX IF NOT EXISTS web_sessions_expires_at_idx ON web_sessions (expires_at);
+//
+// Expired items are not returned, but remain in the table until they are
+// removed with GC or RunGC.
+package sqlkv
@@ -0,0 +1,10 @@
+module github.com/lstoll/session/sqlkv
+
+go 1.22.0
+
+require (
+	github.com/lstoll/session v0.1.0
+	github.com/mattn/go-sqlite3 v1.14.33
+)
+
+require google.golang.org/protobuf v1.36.4 // indirect
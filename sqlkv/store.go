@@ -0,0 +1,164 @@
+package sqlkv
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lstoll/session"
+)
+
+const (
+	DefaultTableName = "web_sessions"
+)
+
+var (
+	_ DBConn = (*sql.DB)(nil)
+	_ DBConn = (*sql.Conn)(nil)
+	_ DBConn = (*sql.Tx)(nil)
+
+	_ session.KV      = (*KV)(nil)
+	_ session.Toucher = (*KV)(nil)
+)
+
+type DBConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type KV struct {
+	conn      DBConn
+	dialect   Dialect
+	tableName string
+	logger    *slog.Logger
+
+	getQuery    string
+	setQuery    string
+	touchQuery  string
+	deleteQuery string
+	gcQuery     string
+}
+
+type Opts struct {
+	TableName string
+	// Logger is used to log background operations. If nil, nothing is logged.
+	Logger *slog.Logger
+}
+
+func New(conn DBConn, dialect Dialect, opts *Opts) (*KV, error) {
+	if !dialect.valid() {
+		return nil, fmt.Errorf("unknown dialect %s", dialect)
+	}
+	tn := DefaultTableName
+	var logger *slog.Logger
+	if opts != nil {
+		if opts.TableName != "" {
+			tn = opts.TableName
+		}
+		logger = opts.Logger
+	}
+	return &KV{
+		conn:      conn,
+		dialect:   dialect,
+		tableName: tn,
+		logger:    logger,
+
+		getQuery:    dialect.query(getQueryTemplate, tn),
+		setQuery:    dialect.query(dialect.setQueryTemplate(), tn),
+		touchQuery:  dialect.query(touchQueryTemplate, tn),
+		deleteQuery: dialect.query(deleteQueryTemplate, tn),
+		gcQuery:     dialect.query(gcQueryTemplate, tn),
+	}, nil
+}
+
+// CreateTable creates the table and its index if they do not exist.
+func (k *KV) CreateTable(ctx context.Context) error {
+	for _, stmt := range k.dialect.schema(k.tableName) {
+		if _, err := k.conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("creating table %s: %w", k.tableName, err)
+		}
+	}
+	return nil
+}
+
+func (k *KV) Get(ctx context.Context, key string) (_ []byte, found bool, _ error) {
+	var data []byte
+	if err := k.conn.QueryRowContext(ctx, k.getQuery, key, k.dialect.timeArg(time.Now())).Scan(&data); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("getting %s: %w", key, err)
+	}
+	return data, true, nil
+}
+
+func (k *KV) Set(ctx context.Context, key string, expiresAt time.Time, value []byte) error {
+	if _, err := k.conn.ExecContext(ctx, k.setQuery, key, value, k.dialect.timeArg(expiresAt)); err != nil {
+		return fmt.Errorf("setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// Touch updates the expiry of key, without rewriting the data.
+func (k *KV) Touch(ctx context.Context, key string, expiresAt time.Time) error {
+	if _, err := k.conn.ExecContext(ctx, k.touchQuery, k.dialect.timeArg(expiresAt), key, k.dialect.timeArg(time.Now())); err != nil {
+		return fmt.Errorf("touching %s: %w", key, err)
+	}
+	return nil
+}
+
+func (k *KV) Delete(ctx context.Context, key string) error {
+	if _, err := k.conn.ExecContext(ctx, k.deleteQuery, key); err != nil {
+		return fmt.Errorf("deleting %s: %w", key, err)
+	}
+	return nil
+}
+
+// GC deletes expired items from the table.
+func (k *KV) GC(ctx context.Context) (deleted int, _ error) {
+	res, err := k.conn.ExecContext(ctx, k.gcQuery, k.dialect.timeArg(time.Now()))
+	if err != nil {
+		return 0, fmt.Errorf("gc: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("gc: %w", err)
+	}
+	return int(n), nil
+}
+
+// RunGC starts a goroutine that calls GC every interval, until ctx is
+// canceled. Results are logged to logger, or the KV's Logger if it is nil.
+func (k *KV) RunGC(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	if logger == nil {
+		logger = k.logger
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				if logger != nil {
+					logger.InfoContext(ctx, "Garbage collection stopped", "reason", ctx.Err())
+				}
+				return
+			case <-ticker.C:
+				deleted, err := k.GC(ctx)
+				if err != nil {
+					if logger != nil {
+						logger.ErrorContext(ctx, "Garbage collection failed", "error", err)
+					}
+				} else {
+					if logger != nil {
+						logger.InfoContext(ctx, "Garbage collection successful", "deleted_rows", deleted)
+					}
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,154 @@
+package sqlkv
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lstoll/session"
+	"github.com/lstoll/session/kvtest"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newSQLiteKV(t *testing.T) *KV {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	kv, err := New(db, DialectSQLite, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.CreateTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return kv
+}
+
+func TestKV(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Touch", func(t *testing.T) {
+		kv := newSQLiteKV(t)
+
+		if err := kv.Set(ctx, "key1", time.Now().Add(-time.Minute), []byte("value1")); err != nil {
+			t.Fatal(err)
+		}
+		if err := kv.Set(ctx, "key2", time.Now().Add(time.Minute), []byte("value2")); err != nil {
+			t.Fatal(err)
+		}
+
+		// expired items should not be revived
+		if err := kv.Touch(ctx, "key1", time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := kv.Get(ctx, "key1"); err != nil || ok {
+			t.Errorf("want expired key not found after touch, got found %t err %v", ok, err)
+		}
+
+		expiresAt := time.Now().Add(time.Hour)
+		if err := kv.Touch(ctx, "key2", expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		var got int64
+		if err := kv.conn.QueryRowContext(ctx, `SELECT expires_at FROM web_sessions WHERE id = 'key2'`).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != expiresAt.UnixMilli() {
+			t.Errorf("want expiry %d, got %d", expiresAt.UnixMilli(), got)
+		}
+	})
+
+	t.Run("GC", func(t *testing.T) {
+		kv := newSQLiteKV(t)
+
+		for key, expiresAt := range map[string]time.Time{
+			"expired1": time.Now().Add(-time.Hour),
+			"expired2": time.Now().Add(-time.Minute),
+			"valid":    time.Now().Add(time.Hour),
+		} {
+			if err := kv.Set(ctx, key, expiresAt, []byte("value")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, ok, err := kv.Get(ctx, "expired1"); err != nil || ok {
+			t.Errorf("want expired key not found before gc, got found %t err %v", ok, err)
+		}
+
+		deleted, err := kv.GC(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != 2 {
+			t.Errorf("want 2 deleted, got %d", deleted)
+		}
+		if _, ok, err := kv.Get(ctx, "valid"); err != nil || !ok {
+			t.Errorf("want valid key kept, got found %t err %v", ok, err)
+		}
+	})
+
+	t.Run("CreateTableExisting", func(t *testing.T) {
+		kv := newSQLiteKV(t)
+		if err := kv.CreateTable(ctx); err != nil {
+			t.Errorf("want creating existing table to succeed, got: %v", err)
+		}
+	})
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(nil, Dialect(0), nil); err == nil {
+		t.Error("want error for unset dialect")
+	}
+
+	for _, tc := range []struct {
+		dialect   Dialect
+		wantGet   string
+		wantSet   string
+		wantTouch string
+	}{
+		{
+			dialect:   DialectSQLite,
+			wantGet:   `SELECT data FROM sessions WHERE id = ? AND expires_at > ?`,
+			wantSet:   `INSERT INTO sessions (id, data, expires_at) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET data = excluded.data, expires_at = excluded.expires_at`,
+			wantTouch: `UPDATE sessions SET expires_at = ? WHERE id = ? AND expires_at > ?`,
+		},
+		{
+			dialect:   DialectMySQL,
+			wantGet:   `SELECT data FROM sessions WHERE id = ? AND expires_at > ?`,
+			wantSet:   `INSERT INTO sessions (id, data, expires_at) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE data = VALUES(data), expires_at = VALUES(expires_at)`,
+			wantTouch: `UPDATE sessions SET expires_at = ? WHERE id = ? AND expires_at > ?`,
+		},
+		{
+			dialect:   DialectPostgres,
+			wantGet:   `SELECT data FROM sessions WHERE id = $1 AND expires_at > $2`,
+			wantSet:   `INSERT INTO sessions (id, data, expires_at) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET data = excluded.data, expires_at = excluded.expires_at`,
+			wantTouch: `UPDATE sessions SET expires_at = $1 WHERE id = $2 AND expires_at > $3`,
+		},
+	} {
+		t.Run(tc.dialect.String(), func(t *testing.T) {
+			kv, err := New(nil, tc.dialect, &Opts{TableName: "sessions"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, q := range []struct{ got, want string }{
+				{kv.getQuery, tc.wantGet},
+				{kv.setQuery, tc.wantSet},
+				{kv.touchQuery, tc.wantTouch},
+			} {
+				if q.got != q.want {
+					t.Errorf("want query:\n%s\ngot:\n%s", q.want, q.got)
+				}
+			}
+		})
+	}
+}
+
+func TestKVConformance(t *testing.T) {
+	kvtest.TestKV(t, func(t *testing.T) session.KV { return newSQLiteKV(t) })
+}
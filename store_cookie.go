@@ -1,11 +1,14 @@
 package session
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -31,7 +34,7 @@ func (c *CookieOpts) newCookie(exp time.Time) *http.Cookie {
 		HttpOnly: true,
 	}
 	if c.Persist {
-		hc.MaxAge = int(time.Until(exp))
+		hc.MaxAge = int(time.Until(exp).Seconds())
 	}
 	return hc
 }
@@ -43,65 +46,159 @@ const (
 	// compressThreshold is the size at which we decide to compress a cookie,
 	// bytes
 	compressThreshold = 512
-	maxCookieSize     = 4096
+	// maxCookieSize is the maximum size of a single cookie's name and value,
+	// bytes. Values larger than this are split across multiple cookies.
+	maxCookieSize = 4096
 )
 
+// DefaultMaxCookieChunks is the default maximum number of cookies a session
+// will be split over.
+const DefaultMaxCookieChunks = 5
+
 var cookieValueEncoding = base64.RawURLEncoding
 
-var _ Store = (*cookieStore)(nil)
+var _ ResaveStore = (*CookieStore)(nil)
 
-type cookieStore struct {
-	AEAD                AEAD
+// CookieStore is a Store that keeps the session data in an encrypted cookie on
+// the client. If the AEAD implements RotatingAEAD, cookies encrypted with a
+// non-primary key are re-encrypted with the primary key on the request they
+// are loaded in.
+type CookieStore struct {
+	aead                AEAD
 	cookieOpts          *CookieOpts
+	compressionDisabled bool
+	compressThreshold   int
+	maxChunks           int
+	observer            Observer
+	logger              *slog.Logger
+}
+
+type CookieStoreOpts struct {
+	// CookieOpts customizes the cookie the session is stored in. If not set,
+	// a __Host- prefixed cookie is used.
+	CookieOpts *CookieOpts
+	// CompressionDisabled disables compression of the cookie data, even if it
+	// is over the threshold.
 	CompressionDisabled bool
+	// CompressThreshold is the size in bytes over which the cookie data is
+	// compressed. Defaults to 512.
+	CompressThreshold int
+	// MaxCookieChunks is the maximum number of cookies a large session will
+	// be split across. Sessions that do not fit in a single cookie are stored
+	// in cookies with the chunk index appended to the name, e.g
+	// __Host-session.0, __Host-session.1. Defaults to
+	// DefaultMaxCookieChunks, setting it to 1 disables chunking.
+	MaxCookieChunks int
+	// Observer is notified of the size of the cookies written.
+	Observer Observer
+	// Logger is used to log cookie handling at debug level. Defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// NewCookieStore creates a Store that persists sessions in cookies, encrypted
+// with the provided AEAD.
+func NewCookieStore(aead AEAD, opts *CookieStoreOpts) (*CookieStore, error) {
+	if aead == nil {
+		return nil, errors.New("AEAD must be provided")
+	}
+
+	c := &CookieStore{
+		aead:              aead,
+		cookieOpts:        defaultCookieStoreCookieOpts,
+		compressThreshold: compressThreshold,
+		maxChunks:         DefaultMaxCookieChunks,
+		observer:          noopObserver{},
+	}
+	if opts != nil {
+		if opts.CookieOpts != nil {
+			c.cookieOpts = opts.CookieOpts
+		}
+		c.compressionDisabled = opts.CompressionDisabled
+		if opts.CompressThreshold < 0 {
+			return nil, fmt.Errorf("compress threshold must not be negative, got %d", opts.CompressThreshold)
+		}
+		if opts.CompressThreshold != 0 {
+			c.compressThreshold = opts.CompressThreshold
+		}
+		if opts.MaxCookieChunks < 0 {
+			return nil, fmt.Errorf("max cookie chunks must not be negative, got %d", opts.MaxCookieChunks)
+		}
+		if opts.MaxCookieChunks != 0 {
+			c.maxChunks = opts.MaxCookieChunks
+		}
+		if opts.Observer != nil {
+			c.observer = opts.Observer
+		}
+		c.logger = opts.Logger
+	}
+
+	if c.cookieOpts.Name == "" {
+		return nil, errors.New("cookie name must be set")
+	}
+	if strings.HasPrefix(c.cookieOpts.Name, "__Host-") && (c.cookieOpts.Path != "/" || c.cookieOpts.Insecure) {
+		return nil, fmt.Errorf("cookie %s has the __Host- prefix, so must be secure with a path of /", c.cookieOpts.Name)
+	}
+	if strings.HasPrefix(c.cookieOpts.Name, "__Secure-") && c.cookieOpts.Insecure {
+		return nil, fmt.Errorf("cookie %s has the __Secure- prefix, so must be secure", c.cookieOpts.Name)
+	}
+
+	return c, nil
 }
 
 // GetSession loads and unmarshals the session in to into
-func (c *cookieStore) GetSession(r *http.Request) ([]byte, error) {
+func (c *CookieStore) GetSession(r *http.Request) ([]byte, error) {
 	// no active session loaded, try and fetch from cookie
-	cookie, err := r.Cookie(c.cookieOpts.Name)
+	cv, err := c.readCookieValue(r)
 	if err != nil {
-		if errors.Is(err, http.ErrNoCookie) {
-			// no session, no op
-			return nil, nil
-		}
-		return nil, fmt.Errorf("getting cookie %s: %w", c.cookieOpts.Name, err)
+		return nil, err
+	}
+	if cv == "" {
+		// no session, no op
+		return nil, nil
 	}
 
-	sp := strings.SplitN(cookie.Value, ".", 2)
+	sp := strings.SplitN(cv, ".", 2)
 	if len(sp) != 2 {
-		return nil, errors.New("cookie does not contain two . separated parts")
+		return nil, fmt.Errorf("%w: cookie does not contain two . separated parts", ErrSessionInvalid)
 	}
 	magic := sp[0]
 	cd, err := cookieValueEncoding.DecodeString(sp[1])
 	if err != nil {
-		return nil, fmt.Errorf("decoding cookie string: %w", err)
+		return nil, fmt.Errorf("%w: decoding cookie string: %w", ErrSessionInvalid, err)
 	}
 
 	if magic != compressedCookieMagic && magic != cookieMagic {
-		return nil, fmt.Errorf("cooking has bad magic prefix: %s", magic)
+		return nil, fmt.Errorf("%w: cookie has bad magic prefix: %s", ErrSessionInvalid, magic)
+	}
+
+	// decrypt
+	db, err := c.aead.Decrypt(cd, []byte(c.cookieOpts.Name))
+	if err != nil {
+		return nil, fmt.Errorf("%w: decrypting cookie: %w", ErrSessionInvalid, err)
+	}
+	if ra, ok := c.aead.(RotatingAEAD); ok && !ra.EncryptedWithPrimary(cd) {
+		loggerOrDefault(c.logger).DebugContext(StoreContext(r), "Session cookie encrypted with a non-primary key, will be re-saved")
+		c.getOrInitCookieSess(r).resave = true
 	}
 
 	// uncompress if needed
 	if magic == compressedCookieMagic {
 		cr := getDecompressor()
 		defer putDecompressor(cr)
-		b, err := cr.Decompress(cd)
+		b, err := cr.Decompress(db)
 		if err != nil {
-			return nil, fmt.Errorf("decompressing cookie: %w", err)
+			return nil, fmt.Errorf("%w: decompressing cookie: %w", ErrSessionInvalid, err)
 		}
-		cd = b
+		db = b
 	}
 
-	// decrypt
-	db, err := c.AEAD.Decrypt(cd, []byte(c.cookieOpts.Name))
-	if err != nil {
-		return nil, fmt.Errorf("decrypting cookie: %w", err)
+	if len(db) < 8 {
+		return nil, fmt.Errorf("%w: cookie data too short", ErrSessionInvalid)
 	}
-
 	expiresAt := time.Unix(int64(binary.LittleEndian.Uint64(db[:8])), 0)
 	if expiresAt.Before(time.Now()) {
-		return nil, fmt.Errorf("cookie expired at %s", expiresAt)
+		return nil, fmt.Errorf("%w: cookie expired at %s", ErrSessionExpired, expiresAt)
 	}
 	db = db[8:]
 
@@ -110,13 +207,13 @@ func (c *cookieStore) GetSession(r *http.Request) ([]byte, error) {
 
 // PutSession saves a session. If a session exists it should be updated, otherwise
 // a new session should be created.
-func (c *cookieStore) PutSession(w http.ResponseWriter, r *http.Request, expiresAt time.Time, data []byte) error {
+func (c *CookieStore) PutSession(w http.ResponseWriter, r *http.Request, expiresAt time.Time, data []byte) error {
 	b := make([]byte, 8)
 	binary.LittleEndian.PutUint64(b, uint64(expiresAt.Unix()))
 	data = append(b, data...)
 
 	magic := cookieMagic
-	if len(data) > compressThreshold {
+	if !c.compressionDisabled && len(data) > c.compressThreshold {
 		cw := getCompressor()
 		defer putCompressor(cw)
 
@@ -129,28 +226,150 @@ func (c *cookieStore) PutSession(w http.ResponseWriter, r *http.Request, expires
 	}
 
 	var err error
-	data, err = c.AEAD.Encrypt(data, []byte(c.cookieOpts.Name))
+	data, err = c.aead.Encrypt(data, []byte(c.cookieOpts.Name))
 	if err != nil {
 		return fmt.Errorf("encrypting cookie failed: %w", err)
 	}
 
 	cv := magic + "." + cookieValueEncoding.EncodeToString(data)
-	if len(cv) > maxCookieSize {
-		return fmt.Errorf("cookie size %d is greater than max %d", len(cv), maxCookieSize)
+
+	var chunks []string
+	if len(c.cookieOpts.Name)+len(cv) <= maxCookieSize {
+		chunks = []string{cv}
+	} else {
+		// leave space for the chunk suffix in the name
+		chunkSize := maxCookieSize - len(c.chunkName(c.maxChunks-1))
+		if len(cv) > c.maxChunks*chunkSize {
+			return fmt.Errorf("cookie size %d is greater than max %d", len(cv), c.maxChunks*chunkSize)
+		}
+		for len(cv) > 0 {
+			n := min(chunkSize, len(cv))
+			chunks = append(chunks, cv[:n])
+			cv = cv[n:]
+		}
+		loggerOrDefault(c.logger).DebugContext(StoreContext(r), "Session cookie split in to chunks", "chunks", len(chunks))
 	}
 
-	cookie := c.cookieOpts.newCookie(expiresAt)
-	cookie.Value = cv
-	http.SetCookie(w, cookie)
+	written := make(map[string]bool, len(chunks))
+	var size int
+	for i, v := range chunks {
+		cookie := c.cookieOpts.newCookie(expiresAt)
+		if len(chunks) > 1 {
+			cookie.Name = c.chunkName(i)
+		}
+		cookie.Value = v
+		removeCookieByName(w, cookie.Name)
+		http.SetCookie(w, cookie)
+		written[cookie.Name] = true
+		size += len(cookie.Name) + len(cookie.Value)
+	}
+	c.observer.RecordSize(StoreContext(r), SizeCookie, size)
+
+	// clean up any cookies from a previous save that are no longer used, e.g
+	// if the session shrunk.
+	for _, name := range c.requestCookieNames(r) {
+		if !written[name] {
+			c.deleteCookie(w, name)
+		}
+	}
 
 	return nil
 }
 
 // Delete deletes the session.
-func (c *cookieStore) DeleteSession(w http.ResponseWriter, r *http.Request) error {
+func (c *CookieStore) DeleteSession(w http.ResponseWriter, r *http.Request) error {
+	c.deleteCookie(w, c.cookieOpts.Name)
+	for _, name := range c.requestCookieNames(r) {
+		if name != c.cookieOpts.Name {
+			c.deleteCookie(w, name)
+		}
+	}
+
+	return nil
+}
+
+// NeedsResave indicates if the cookie loaded for this request was encrypted
+// with a key that is not the AEAD's current primary, and should be re-saved.
+func (c *CookieStore) NeedsResave(r *http.Request) bool {
+	cs, ok := r.Context().Value(cookieSessCtxKey{inst: c}).(*cookieSession)
+	return ok && cs.resave
+}
+
+// readCookieValue returns the session cookie value from the request, joining it
+// back together if it was split over multiple cookies. If there is no session
+// cookie, an empty string is returned.
+func (c *CookieStore) readCookieValue(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(c.cookieOpts.Name)
+	if err == nil {
+		return cookie.Value, nil
+	}
+	if !errors.Is(err, http.ErrNoCookie) {
+		return "", fmt.Errorf("getting cookie %s: %w", c.cookieOpts.Name, err)
+	}
+
+	var sb strings.Builder
+	for i := range c.maxChunks {
+		cookie, err := r.Cookie(c.chunkName(i))
+		if err != nil {
+			if errors.Is(err, http.ErrNoCookie) {
+				break
+			}
+			return "", fmt.Errorf("getting cookie %s: %w", c.chunkName(i), err)
+		}
+		sb.WriteString(cookie.Value)
+	}
+
+	return sb.String(), nil
+}
+
+// requestCookieNames returns the names of all the session cookies, chunked or
+// not, that were sent with the request.
+func (c *CookieStore) requestCookieNames(r *http.Request) []string {
+	var names []string
+	for _, cookie := range r.Cookies() {
+		if cookie.Name == c.cookieOpts.Name {
+			names = append(names, cookie.Name)
+			continue
+		}
+		idx, ok := strings.CutPrefix(cookie.Name, c.cookieOpts.Name+".")
+		if !ok {
+			continue
+		}
+		if _, err := strconv.Atoi(idx); err == nil {
+			names = append(names, cookie.Name)
+		}
+	}
+	return names
+}
+
+func (c *CookieStore) chunkName(idx int) string {
+	return c.cookieOpts.Name + "." + strconv.Itoa(idx)
+}
+
+func (c *CookieStore) deleteCookie(w http.ResponseWriter, name string) {
 	dc := c.cookieOpts.newCookie(time.Time{})
+	dc.Name = name
 	dc.MaxAge = -1
+	removeCookieByName(w, dc.Name)
 	http.SetCookie(w, dc)
+}
 
-	return nil
+func (c *CookieStore) getOrInitCookieSess(r *http.Request) *cookieSession {
+	cs, ok := r.Context().Value(cookieSessCtxKey{inst: c}).(*cookieSession)
+	if ok {
+		return cs
+	}
+
+	cs = &cookieSession{}
+	*r = *r.WithContext(context.WithValue(r.Context(), cookieSessCtxKey{inst: c}, cs))
+
+	return cs
+}
+
+type cookieSessCtxKey struct{ inst *CookieStore }
+
+// cookieSession tracks information about the session across the request's
+// context
+type cookieSession struct {
+	resave bool
 }
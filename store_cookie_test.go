@@ -0,0 +1,456 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewCookieStore(t *testing.T) {
+	aead, err := NewAESGCMKeyset([]AESGCMKey{{ID: 1, Key: genAESKey(), State: KeyStatePrimary}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		aead    AEAD
+		opts    *CookieStoreOpts
+		wantErr bool
+		// wantMaxAge is the MaxAge of a cookie saved to expire in an hour.
+		wantMaxAge int
+	}{
+		{
+			name: "Defaults",
+			aead: aead,
+		},
+		{
+			name:    "No AEAD",
+			wantErr: true,
+		},
+		{
+			name: "Custom cookie",
+			aead: aead,
+			opts: &CookieStoreOpts{
+				CookieOpts: &CookieOpts{Name: "sess", Path: "/app", Insecure: true},
+			},
+		},
+		{
+			name: "Persistent cookie",
+			aead: aead,
+			opts: &CookieStoreOpts{
+				CookieOpts: &CookieOpts{Name: "__Host-sess", Path: "/", Persist: true},
+			},
+			wantMaxAge: 3600,
+		},
+		{
+			name: "No cookie name",
+			aead: aead,
+			opts: &CookieStoreOpts{
+				CookieOpts: &CookieOpts{Path: "/"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Host prefix with path",
+			aead: aead,
+			opts: &CookieStoreOpts{
+				CookieOpts: &CookieOpts{Name: "__Host-sess", Path: "/app"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Host prefix insecure",
+			aead: aead,
+			opts: &CookieStoreOpts{
+				CookieOpts: &CookieOpts{Name: "__Host-sess", Path: "/", Insecure: true},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Secure prefix insecure",
+			aead: aead,
+			opts: &CookieStoreOpts{
+				CookieOpts: &CookieOpts{Name: "__Secure-sess", Path: "/", Insecure: true},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "Negative compress threshold",
+			aead:    aead,
+			opts:    &CookieStoreOpts{CompressThreshold: -1},
+			wantErr: true,
+		},
+		{
+			name:    "Negative max chunks",
+			aead:    aead,
+			opts:    &CookieStoreOpts{MaxCookieChunks: -1},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewCookieStore(tt.aead, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewCookieStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if err := store.PutSession(rec, r, time.Now().Add(time.Hour), []byte("data")); err != nil {
+				t.Fatal(err)
+			}
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("want 1 cookie, got %d", len(cookies))
+			}
+			// allow for the time elapsed since the expiry was calculated
+			if got := cookies[0].MaxAge; got > tt.wantMaxAge || got < tt.wantMaxAge-1 {
+				t.Errorf("want cookie MaxAge %d, got %d", tt.wantMaxAge, got)
+			}
+		})
+	}
+}
+
+func TestCookieStoreCompression(t *testing.T) {
+	aead, err := NewAESGCMKeyset([]AESGCMKey{{ID: 1, Key: genAESKey(), State: KeyStatePrimary}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("a"), 1024)
+
+	for _, tc := range []struct {
+		name      string
+		opts      *CookieStoreOpts
+		wantMagic string
+	}{
+		{
+			name:      "Default",
+			wantMagic: compressedCookieMagic,
+		},
+		{
+			name:      "Disabled",
+			opts:      &CookieStoreOpts{CompressionDisabled: true},
+			wantMagic: cookieMagic,
+		},
+		{
+			name:      "Raised threshold",
+			opts:      &CookieStoreOpts{CompressThreshold: 2048},
+			wantMagic: cookieMagic,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cs, err := NewCookieStore(aead, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if err := cs.PutSession(rec, r, time.Now().Add(time.Hour), data); err != nil {
+				t.Fatal(err)
+			}
+
+			r = httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, c := range rec.Result().Cookies() {
+				if !strings.HasPrefix(c.Value, tc.wantMagic+".") {
+					t.Errorf("want cookie with magic %s, got: %s", tc.wantMagic, c.Value[:3])
+				}
+				r.AddCookie(c)
+			}
+
+			got, err := cs.GetSession(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, got) {
+				t.Error("loaded data does not match saved")
+			}
+		})
+	}
+}
+
+func TestCookieStoreKeyRotation(t *testing.T) {
+	var (
+		key1 = genAESKey()
+		key2 = genAESKey()
+	)
+
+	newMgr := func(keys []AESGCMKey) *Manager[*jsonTestSession] {
+		ks, err := NewAESGCMKeyset(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs, err := NewCookieStore(ks, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// no idle timeout, so the session is only written when required.
+		mgr, err := NewManager[jsonTestSession](cs, &ManagerOpts[*jsonTestSession]{
+			MaxLifetime: time.Hour,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mgr
+	}
+
+	serve := func(mgr *Manager[*jsonTestSession], h http.HandlerFunc, cookies []*http.Cookie) []*http.Cookie {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		mgr.Wrap(h).ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("want status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return rec.Result().Cookies()
+	}
+
+	mgr1 := newMgr([]AESGCMKey{{ID: 1, Key: key1, State: KeyStatePrimary}})
+	cookies := serve(mgr1, func(w http.ResponseWriter, r *http.Request) {
+		mgr1.Save(r.Context(), &jsonTestSession{KV: map[string]string{"k": "v"}})
+	}, nil)
+	if len(cookies) != 1 {
+		t.Fatalf("want 1 cookie set, got %d", len(cookies))
+	}
+
+	readHandler := func(mgr *Manager[*jsonTestSession]) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if got := mgr.Get(r.Context()).KV["k"]; got != "v" {
+				t.Errorf("want session value v, got %q", got)
+			}
+		}
+	}
+
+	// loading with the primary key should not re-save
+	if got := serve(mgr1, readHandler(mgr1), cookies); len(got) != 0 {
+		t.Errorf("want no cookie set when loaded with primary key, got %d", len(got))
+	}
+
+	// after rotation, the read should re-encrypt the cookie with the new key.
+	mgr2 := newMgr([]AESGCMKey{
+		{ID: 1, Key: key1, State: KeyStateDecryptOnly},
+		{ID: 2, Key: key2, State: KeyStatePrimary},
+	})
+	rotated := serve(mgr2, readHandler(mgr2), cookies)
+	if len(rotated) != 1 {
+		t.Fatalf("want cookie re-saved after rotation, got %d cookies", len(rotated))
+	}
+
+	// the re-saved cookie should be readable once the old key is retired
+	mgr3 := newMgr([]AESGCMKey{
+		{ID: 1, Key: key1, State: KeyStateRetired},
+		{ID: 2, Key: key2, State: KeyStatePrimary},
+	})
+	if got := serve(mgr3, readHandler(mgr3), rotated); len(got) != 0 {
+		t.Errorf("want no cookie set when loaded with primary key, got %d", len(got))
+	}
+}
+
+func TestCookieStoreChunking(t *testing.T) {
+	aead, err := NewAESGCMKeyset([]AESGCMKey{{ID: 1, Key: genAESKey(), State: KeyStatePrimary}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := NewCookieStore(aead, &CookieStoreOpts{CompressionDisabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(reqCookies []*http.Cookie, data []byte) []*http.Cookie {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, c := range reqCookies {
+			r.AddCookie(c)
+		}
+		if err := cs.PutSession(rec, r, time.Now().Add(time.Hour), data); err != nil {
+			t.Fatal(err)
+		}
+		assertNoDuplicateCookies(t, rec.Result().Cookies())
+		return rec.Result().Cookies()
+	}
+
+	get := func(cookies []*http.Cookie) []byte {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, c := range cookies {
+			if c.MaxAge >= 0 {
+				r.AddCookie(c)
+			}
+		}
+		got, err := cs.GetSession(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	large := bytes.Repeat([]byte("0123456789"), 1000)
+	cookies := put(nil, large)
+	nChunks := len(cookies)
+	if nChunks < 2 {
+		t.Fatalf("want session split over multiple cookies, got %d", nChunks)
+	}
+	for i, c := range cookies {
+		if want := "__Host-session." + strconv.Itoa(i); c.Name != want {
+			t.Errorf("want cookie %d named %s, got %s", i, want, c.Name)
+		}
+		if len(c.Name)+len(c.Value) > maxCookieSize {
+			t.Errorf("cookie %s is larger than the max size", c.Name)
+		}
+	}
+	if got := get(cookies); !bytes.Equal(large, got) {
+		t.Error("loaded data does not match saved")
+	}
+
+	// shrinking the session should remove the chunks
+	small := []byte("small")
+	shrunk := put(cookies, small)
+	var live []*http.Cookie
+	for _, c := range shrunk {
+		if c.MaxAge < 0 {
+			if !strings.HasPrefix(c.Name, "__Host-session.") {
+				t.Errorf("unexpected cookie deleted: %s", c.Name)
+			}
+			continue
+		}
+		live = append(live, c)
+	}
+	if len(shrunk) != nChunks+1 || len(live) != 1 || live[0].Name != "__Host-session" {
+		t.Fatalf("want single session cookie and %d chunks deleted, got %d cookies (%d live)", nChunks, len(shrunk), len(live))
+	}
+	if got := get(live); !bytes.Equal(small, got) {
+		t.Error("loaded data does not match saved")
+	}
+
+	// growing again should remove the un-chunked cookie
+	grown := put(live, large)
+	if len(grown) != nChunks+1 {
+		t.Fatalf("want %d chunks and a deleted cookie, got %d", nChunks, len(grown))
+	}
+	if got := get(grown); !bytes.Equal(large, got) {
+		t.Error("loaded data does not match saved")
+	}
+
+	// deleting should clear all the chunks
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	if err := cs.DeleteSession(rec, r); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(rec.Result().Cookies()); got != nChunks+1 {
+		t.Errorf("want %d cookies deleted, got %d", nChunks+1, got)
+	}
+
+	// too large fails
+	huge := bytes.Repeat([]byte("0123456789"), 3000)
+	if err := cs.PutSession(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), time.Now().Add(time.Hour), huge); err == nil {
+		t.Error("want error saving session larger than max chunks")
+	}
+}
+
+func TestCookieStoreInvalidSessions(t *testing.T) {
+	aead, err := NewAESGCMKeyset([]AESGCMKey{{ID: 1, Key: genAESKey(), State: KeyStatePrimary}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := NewCookieStore(aead, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := func(expiresAt time.Time) *http.Cookie {
+		rec := httptest.NewRecorder()
+		if err := cs.PutSession(rec, httptest.NewRequest(http.MethodGet, "/", nil), expiresAt, []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Result().Cookies()[0]
+	}
+
+	valid := saved(time.Now().Add(time.Hour))
+	tampered := *valid
+	tampered.Value = tampered.Value[:len(tampered.Value)-4] + "AAAA"
+
+	for _, tc := range []struct {
+		name    string
+		cookie  *http.Cookie
+		wantErr error
+	}{
+		{
+			name:    "Expired",
+			cookie:  saved(time.Now().Add(-time.Minute)),
+			wantErr: ErrSessionExpired,
+		},
+		{
+			name:    "Tampered",
+			cookie:  &tampered,
+			wantErr: ErrSessionInvalid,
+		},
+		{
+			name:    "Bad magic",
+			cookie:  &http.Cookie{Name: valid.Name, Value: "XX1" + valid.Value[3:]},
+			wantErr: ErrSessionInvalid,
+		},
+		{
+			name:    "Not encoded",
+			cookie:  &http.Cookie{Name: valid.Name, Value: "EU1.!!!"},
+			wantErr: ErrSessionInvalid,
+		},
+		{
+			name:    "Garbage",
+			cookie:  &http.Cookie{Name: valid.Name, Value: "garbage"},
+			wantErr: ErrSessionInvalid,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(tc.cookie)
+			if _, err := cs.GetSession(r); !errors.Is(err, tc.wantErr) {
+				t.Errorf("want error %v, got: %v", tc.wantErr, err)
+			}
+
+			for _, strict := range []bool{false, true} {
+				mgr, err := NewManager[jsonTestSession](cs, &ManagerOpts[*jsonTestSession]{
+					IdleTimeout:           time.Hour,
+					RejectInvalidSessions: strict,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				rec := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.AddCookie(tc.cookie)
+				mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, r)
+
+				if strict {
+					if rec.Code != http.StatusInternalServerError {
+						t.Errorf("strict: want status 500, got %d", rec.Code)
+					}
+					continue
+				}
+				if rec.Code != http.StatusOK {
+					t.Errorf("want status 200, got %d", rec.Code)
+				}
+				cookies := rec.Result().Cookies()
+				if len(cookies) != 1 || cookies[0].Name != valid.Name || cookies[0].MaxAge >= 0 {
+					t.Errorf("want session cookie cleared, got: %v", cookies)
+				}
+			}
+		})
+	}
+}
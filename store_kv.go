@@ -6,7 +6,10 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 )
@@ -22,26 +25,185 @@ type KV interface {
 	Delete(_ context.Context, key string) error
 }
 
-var _ Store = (*KVStore)(nil)
+// Toucher can optionally be implemented by a KV, to extend the expiry of an
+// item without rewriting its value.
+type Toucher interface {
+	// Touch updates the expiry of key. If the key does not exist, it should
+	// no-op.
+	Touch(_ context.Context, key string, expiresAt time.Time) error
+}
+
+// UserIndexer can optionally be implemented by a KV, to maintain an index of
+// items by the user they belong to.
+type UserIndexer interface {
+	// SetUser associates key with userID and the device it was used from,
+	// replacing any existing association. The association is removed when the
+	// key is deleted or expires, and is retained when the key's value is
+	// updated.
+	SetUser(_ context.Context, key, userID string, device DeviceInfo) error
+	// ListUser returns the unexpired items associated with userID, oldest
+	// first.
+	ListUser(_ context.Context, userID string) ([]UserSession, error)
+	// DeleteUser deletes all the items associated with userID.
+	DeleteUser(_ context.Context, userID string) (deleted int, _ error)
+}
+
+// VersionedKV can optionally be implemented by a KV, to support optimistic
+// concurrency control. Every write of an item's value increments its version,
+// extending its expiry with Touch does not.
+type VersionedKV interface {
+	// GetVersioned is Get, additionally returning the item's version.
+	GetVersioned(_ context.Context, key string) (_ []byte, version int64, found bool, _ error)
+	// CompareAndSet is Set, but only writes the item if its current version
+	// matches version. A version of 0 indicates the item must not exist, in
+	// which case it is created with version 1. If the version does not match,
+	// ErrConflict is returned.
+	CompareAndSet(_ context.Context, key string, version int64, expiresAt time.Time, value []byte) error
+}
+
+// Locker can optionally be implemented by a KV, to provide exclusive locks
+// used to serialize requests for a session.
+type Locker interface {
+	// Lock acquires an exclusive lock on key, waiting until it is available
+	// or ctx is done. The lock is held until unlock is called. The key does
+	// not need to exist.
+	Lock(ctx context.Context, key string) (unlock func(), _ error)
+}
+
+// LockChecker can optionally be implemented by a KV that implements Locker,
+// but can only lock in some configurations.
+type LockChecker interface {
+	// CheckLockable returns an error if Lock is not supported.
+	CheckLockable() error
+}
+
+// DeviceInfo describes the client a session was established from.
+type DeviceInfo struct {
+	UserAgent string
+	// IP is the client's address, as seen by the server. It is approximate,
+	// as the client may be behind a proxy.
+	IP string
+}
+
+// UserSession describes one of a user's active sessions.
+type UserSession struct {
+	// ID identifies the session. For a KVStore this is the key it is stored
+	// under, and matches Metadata.ID.
+	ID string
+	// CreatedAt is when the session was first stored.
+	CreatedAt time.Time
+	// LastSeenAt is when the session was last saved or had its expiry
+	// extended.
+	LastSeenAt time.Time
+	// Device is the client the session was associated with the user from.
+	Device DeviceInfo
+}
+
+var (
+	// ErrUserIndexUnsupported is returned when a user operation is performed
+	// on a KVStore whose KV does not implement UserIndexer.
+	ErrUserIndexUnsupported = errors.New("KV does not support indexing by user")
+	// ErrSessionNotFound is returned by RevokeSession when the user has no
+	// session with the given ID.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrTooManySessions is returned when a session is associated with a user
+	// that already has MaxSessionsPerUser sessions, and the eviction policy is
+	// EvictRefuse.
+	ErrTooManySessions = errors.New("user has too many sessions")
+)
+
+// EvictionPolicy determines what happens when a user exceeds the maximum
+// number of sessions.
+type EvictionPolicy int
+
+const (
+	// EvictOldest deletes the user's sessions that were created first.
+	EvictOldest EvictionPolicy = iota
+	// EvictLeastRecentlyUsed deletes the user's sessions that were last seen
+	// the longest time ago.
+	EvictLeastRecentlyUsed
+	// EvictRefuse refuses to associate the new session with the user. The new
+	// session is deleted, and ErrTooManySessions is passed to the Manager's
+	// ErrorHandler.
+	EvictRefuse
+)
+
+var (
+	_ IDStore        = (*KVStore)(nil)
+	_ TouchStore     = (*KVStore)(nil)
+	_ UserStore      = (*KVStore)(nil)
+	_ VersionedStore = (*KVStore)(nil)
+	_ LockingStore   = (*KVStore)(nil)
+)
 
 type KVStore struct {
-	kv         KV
-	cookieOpts *CookieOpts
+	kv          KV
+	cookieOpts  *CookieOpts
+	clientIP    func(r *http.Request) string
+	maxSessions int
+	eviction    EvictionPolicy
+	observer    Observer
+	logger      *slog.Logger
 }
 
 type KVStoreOpts struct {
 	CookieOpts *CookieOpts
+	// ClientIP returns the IP address recorded for a user's session. If the
+	// server is behind a proxy this should be set to extract it from the
+	// appropriate header. Defaults to the host part of the request's
+	// RemoteAddr.
+	ClientIP func(r *http.Request) string
+	// MaxSessionsPerUser limits the number of sessions a user can have. It is
+	// enforced when a session is associated with the user, according to the
+	// Eviction policy. The limit is best-effort, concurrent logins may briefly
+	// exceed it. Zero means no limit. The KV must implement UserIndexer.
+	MaxSessionsPerUser int
+	// Eviction determines how MaxSessionsPerUser is enforced. Defaults to
+	// EvictOldest.
+	Eviction EvictionPolicy
+	// Observer is notified with EventExpired when a request's cookie refers
+	// to a session that is no longer in the KV. The KV does not distinguish
+	// expired sessions from deleted ones, so this includes revoked sessions.
+	Observer Observer
+	// Logger is used to log user session management at debug level. Session
+	// and user IDs are hashed before they are logged. Defaults to
+	// slog.Default().
+	Logger *slog.Logger
 }
 
 func NewKVStore(kv KV, opts *KVStoreOpts) (*KVStore, error) {
 	s := &KVStore{
 		kv:         kv,
 		cookieOpts: DefaultKVStoreCookieOpts,
+		clientIP:   remoteAddrIP,
+		observer:   noopObserver{},
 	}
 	if opts != nil {
 		if opts.CookieOpts != nil {
 			s.cookieOpts = opts.CookieOpts
 		}
+		if opts.ClientIP != nil {
+			s.clientIP = opts.ClientIP
+		}
+		if opts.MaxSessionsPerUser < 0 {
+			return nil, fmt.Errorf("max sessions per user must not be negative, got %d", opts.MaxSessionsPerUser)
+		}
+		if opts.MaxSessionsPerUser > 0 {
+			if _, ok := kv.(UserIndexer); !ok {
+				return nil, fmt.Errorf("max sessions per user set: %w", ErrUserIndexUnsupported)
+			}
+		}
+		switch opts.Eviction {
+		case EvictOldest, EvictLeastRecentlyUsed, EvictRefuse:
+		default:
+			return nil, fmt.Errorf("unknown eviction policy %d", opts.Eviction)
+		}
+		s.maxSessions = opts.MaxSessionsPerUser
+		s.eviction = opts.Eviction
+		if opts.Observer != nil {
+			s.observer = opts.Observer
+		}
+		s.logger = opts.Logger
 	}
 	return s, nil
 }
@@ -52,6 +214,7 @@ func (k *KVStore) GetSession(r *http.Request) ([]byte, error) {
 
 	// TODO(lstoll) differentiate deleted vs. emptied
 
+	var fromCookie bool
 	if kvSess.id == "" {
 		// no active session loaded, try and fetch from cookie
 		cookie, err := r.Cookie(k.cookieOpts.Name)
@@ -63,15 +226,40 @@ func (k *KVStore) GetSession(r *http.Request) ([]byte, error) {
 			return nil, fmt.Errorf("getting cookie %s: %w", k.cookieOpts.Name, err)
 		}
 		kvSess.id = cookie.Value
+		fromCookie = true
 	}
 
-	b, ok, err := k.kv.Get(r.Context(), k.storeID(kvSess.id))
+	var (
+		b   []byte
+		ok  bool
+		err error
+	)
+	if vkv, isv := k.kv.(VersionedKV); isv {
+		b, kvSess.version, ok, err = vkv.GetVersioned(StoreContext(r), k.storeID(kvSess.id))
+	} else {
+		b, ok, err = k.kv.Get(StoreContext(r), k.storeID(kvSess.id))
+	}
 	if err != nil {
+		if fromCookie {
+			// the ID hasn't been verified, so don't let a session saved if
+			// the request continues use it.
+			kvSess.id = newSID()
+			kvSess.version = 0
+		}
 		return nil, fmt.Errorf("loading from KV: %w", err)
 	}
 	if !ok {
+		if fromCookie {
+			k.observer.SessionEvent(StoreContext(r), EventExpired)
+		}
+		// don't reuse the ID for a new session, it may have expired or been
+		// chosen by the client.
+		kvSess.id = newSID()
+		kvSess.version = 0
+		kvSess.data = nil
 		return nil, nil
 	}
+	kvSess.data = b
 
 	return b, nil
 }
@@ -84,20 +272,113 @@ func (k *KVStore) PutSession(w http.ResponseWriter, r *http.Request, expiresAt t
 		kvSess.id = newSID()
 	}
 
-	if err := k.kv.Set(r.Context(), k.storeID(kvSess.id), expiresAt, data); err != nil {
+	if err := k.kv.Set(StoreContext(r), k.storeID(kvSess.id), expiresAt, data); err != nil {
 		return fmt.Errorf("putting session data: %w", err)
 	}
+	kvSess.data = data
 
-	c := k.cookieOpts.newCookie(expiresAt)
-	c.Expires = expiresAt
-	c.Value = kvSess.id
+	k.setCookie(w, kvSess.id, expiresAt)
 
-	removeCookieByName(w, c.Name)
-	http.SetCookie(w, c)
+	return nil
+}
+
+// PutSessionIfUnchanged saves the session like PutSession, if it has not been
+// written since it was loaded in this request. The KV must implement
+// VersionedKV.
+func (k *KVStore) PutSessionIfUnchanged(w http.ResponseWriter, r *http.Request, expiresAt time.Time, data []byte) error {
+	vkv, ok := k.kv.(VersionedKV)
+	if !ok {
+		return errors.New("KV does not support versioning")
+	}
+	kvSess := k.getOrInitKVSess(r)
+	if kvSess.id == "" {
+		kvSess.id = newSID()
+	}
+
+	if err := vkv.CompareAndSet(StoreContext(r), k.storeID(kvSess.id), kvSess.version, expiresAt, data); err != nil {
+		return fmt.Errorf("putting session data: %w", err)
+	}
+	kvSess.version++
+	kvSess.data = data
+
+	k.setCookie(w, kvSess.id, expiresAt)
+
+	return nil
+}
+
+// checkVersioned returns an error if the KV does not implement VersionedKV.
+func (k *KVStore) checkVersioned() error {
+	if _, ok := k.kv.(VersionedKV); !ok {
+		return fmt.Errorf("KV %T does not implement VersionedKV", k.kv)
+	}
+	return nil
+}
+
+// checkLockable returns an error if the KV does not implement Locker, or
+// reports that it can't lock.
+func (k *KVStore) checkLockable() error {
+	if _, ok := k.kv.(Locker); !ok {
+		return fmt.Errorf("KV %T does not implement Locker", k.kv)
+	}
+	if lc, ok := k.kv.(LockChecker); ok {
+		if err := lc.CheckLockable(); err != nil {
+			return fmt.Errorf("KV %T can't lock: %w", k.kv, err)
+		}
+	}
+	return nil
+}
+
+// LockSession acquires a lock on the session identified by the request's
+// cookie. The KV must implement Locker.
+func (k *KVStore) LockSession(ctx context.Context, r *http.Request) (unlock func(), _ error) {
+	l, ok := k.kv.(Locker)
+	if !ok {
+		return nil, errors.New("KV does not support locking")
+	}
+	cookie, err := r.Cookie(k.cookieOpts.Name)
+	if err != nil {
+		// no session to lock, a new one will get a unique ID.
+		return func() {}, nil
+	}
+	unlock, err = l.Lock(ctx, k.storeID(cookie.Value))
+	if err != nil {
+		return nil, fmt.Errorf("locking session: %w", err)
+	}
+	return unlock, nil
+}
+
+// TouchSession extends the expiry of the session loaded for the request. If
+// the KV implements Toucher it is used to update the expiry, otherwise the
+// loaded data is re-saved.
+func (k *KVStore) TouchSession(w http.ResponseWriter, r *http.Request, expiresAt time.Time) error {
+	kvSess := k.getOrInitKVSess(r)
+	if kvSess.id == "" || kvSess.data == nil {
+		// nothing loaded to touch
+		return nil
+	}
+
+	if t, ok := k.kv.(Toucher); ok {
+		if err := t.Touch(StoreContext(r), k.storeID(kvSess.id), expiresAt); err != nil {
+			return fmt.Errorf("touching session: %w", err)
+		}
+	} else {
+		if err := k.kv.Set(StoreContext(r), k.storeID(kvSess.id), expiresAt, kvSess.data); err != nil {
+			return fmt.Errorf("putting session data: %w", err)
+		}
+	}
+
+	k.setCookie(w, kvSess.id, expiresAt)
 
 	return nil
 }
 
+// touchesNatively returns true if the KV can extend the expiry of an item
+// without rewriting it.
+func (k *KVStore) touchesNatively() bool {
+	_, ok := k.kv.(Toucher)
+	return ok
+}
+
 // DeleteSession deletes the session.
 func (k *KVStore) DeleteSession(w http.ResponseWriter, r *http.Request) error {
 	kvSess := k.getOrInitKVSess(r)
@@ -106,8 +387,8 @@ func (k *KVStore) DeleteSession(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
-	if err := k.kv.Delete(r.Context(), k.storeID(kvSess.id)); err != nil {
-		return fmt.Errorf("deleting session %s from store: %w", kvSess.id, err)
+	if err := k.kv.Delete(StoreContext(r), k.storeID(kvSess.id)); err != nil {
+		return fmt.Errorf("deleting session from store: %w", err)
 	}
 
 	// always clear the cookie
@@ -121,10 +402,191 @@ func (k *KVStore) DeleteSession(w http.ResponseWriter, r *http.Request) error {
 	// If not, it's ignored. This prevents a `Get` from trying to re-load from
 	// the cookie.
 	kvSess.id = newSID()
+	kvSess.version = 0
+	kvSess.data = nil
+
+	return nil
+}
+
+// SetSessionUser associates the session saved for the request with userID, so
+// it is listed by ListUserSessions and removed by RevokeUser. The request's
+// user agent and IP are recorded with it, and MaxSessionsPerUser is enforced.
+// The KV must implement UserIndexer.
+func (k *KVStore) SetSessionUser(r *http.Request, userID string) error {
+	ui, ok := k.kv.(UserIndexer)
+	if !ok {
+		return ErrUserIndexUnsupported
+	}
+	kvSess := k.getOrInitKVSess(r)
+	if kvSess.id == "" {
+		return errors.New("no session to associate with user")
+	}
+	key := k.storeID(kvSess.id)
+	ctx := StoreContext(r)
+
+	if k.maxSessions > 0 && k.eviction == EvictRefuse {
+		sessions, err := ui.ListUser(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("listing sessions for user: %w", err)
+		}
+		sessions = slices.DeleteFunc(sessions, func(s UserSession) bool { return s.ID == key })
+		if len(sessions) >= k.maxSessions {
+			// don't leave the refused session usable
+			if err := k.kv.Delete(ctx, key); err != nil {
+				return fmt.Errorf("deleting refused session: %w", err)
+			}
+			kvSess.data = nil
+			loggerOrDefault(k.logger).DebugContext(ctx, "Refusing session, user has too many sessions",
+				hashedAttr("session", key), hashedAttr("user", userID))
+			return ErrTooManySessions
+		}
+	}
+
+	device := DeviceInfo{
+		UserAgent: r.UserAgent(),
+		IP:        k.clientIP(r),
+	}
+	if err := ui.SetUser(ctx, key, userID, device); err != nil {
+		return fmt.Errorf("setting session user: %w", err)
+	}
+	loggerOrDefault(k.logger).DebugContext(ctx, "Session associated with user",
+		hashedAttr("session", key), hashedAttr("user", userID))
+
+	if k.maxSessions > 0 && k.eviction != EvictRefuse {
+		if err := k.evict(ctx, ui, userID, key); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// evict deletes the user's sessions over the limit according to the eviction
+// policy, never deleting the session stored under keep.
+func (k *KVStore) evict(ctx context.Context, ui UserIndexer, userID, keep string) error {
+	sessions, err := ui.ListUser(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("listing sessions for user: %w", err)
+	}
+	sessions = slices.DeleteFunc(sessions, func(s UserSession) bool { return s.ID == keep })
+	// the kept session counts towards the limit
+	excess := len(sessions) + 1 - k.maxSessions
+	if excess <= 0 {
+		return nil
+	}
+
+	if k.eviction == EvictLeastRecentlyUsed {
+		slices.SortStableFunc(sessions, func(a, b UserSession) int {
+			return a.LastSeenAt.Compare(b.LastSeenAt)
+		})
+	} else {
+		slices.SortStableFunc(sessions, func(a, b UserSession) int {
+			return a.CreatedAt.Compare(b.CreatedAt)
+		})
+	}
+
+	for _, s := range sessions[:excess] {
+		if err := k.kv.Delete(ctx, s.ID); err != nil {
+			return fmt.Errorf("evicting session: %w", err)
+		}
+		loggerOrDefault(k.logger).DebugContext(ctx, "Evicted session, user has too many sessions",
+			hashedAttr("session", s.ID), hashedAttr("user", userID))
+	}
+	return nil
+}
+
+// ListUserSessions returns the active sessions for userID, oldest first. The
+// current request's session can be identified by comparing the IDs with
+// Metadata.ID. The KV must implement UserIndexer.
+func (k *KVStore) ListUserSessions(ctx context.Context, userID string) ([]UserSession, error) {
+	if userID == "" {
+		return nil, errors.New("user ID must be set")
+	}
+	ui, ok := k.kv.(UserIndexer)
+	if !ok {
+		return nil, ErrUserIndexUnsupported
+	}
+	sessions, err := ui.ListUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("listing sessions for user: %w", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession deletes a single session belonging to userID, identified by
+// its ID from ListUserSessions. Sessions that do not belong to the user are
+// not deleted, and ErrSessionNotFound is returned. The KV must implement
+// UserIndexer.
+func (k *KVStore) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	sessions, err := k.ListUserSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		if s.ID == sessionID {
+			if err := k.kv.Delete(ctx, sessionID); err != nil {
+				return fmt.Errorf("deleting session: %w", err)
+			}
+			loggerOrDefault(k.logger).DebugContext(ctx, "Revoked session",
+				hashedAttr("session", sessionID), hashedAttr("user", userID))
+			return nil
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// RevokeUser deletes all sessions associated with userID, e.g after a password
+// change. It can be called outside of a request. The KV must implement
+// UserIndexer.
+func (k *KVStore) RevokeUser(ctx context.Context, userID string) error {
+	if userID == "" {
+		return errors.New("user ID must be set")
+	}
+	ui, ok := k.kv.(UserIndexer)
+	if !ok {
+		return ErrUserIndexUnsupported
+	}
+	deleted, err := ui.DeleteUser(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("deleting sessions for user: %w", err)
+	}
+	loggerOrDefault(k.logger).DebugContext(ctx, "Revoked user sessions",
+		hashedAttr("user", userID), "deleted", deleted)
+	return nil
+}
+
+// SessionID returns a hash of the session's ID, which is also the key it is
+// stored under in the KV. If the request does not have a session yet, an ID is
+// assigned that will be used when it is saved.
+func (k *KVStore) SessionID(ctx context.Context) string {
+	kvSess, ok := ctx.Value(kvSessCtxKey{inst: k}).(*kvSession)
+	if !ok {
+		return ""
+	}
+	if kvSess.id == "" {
+		kvSess.id = newSID()
+	}
+	return k.storeID(kvSess.id)
+}
+
+func (k *KVStore) setCookie(w http.ResponseWriter, id string, expiresAt time.Time) {
+	c := k.cookieOpts.newCookie(expiresAt)
+	c.Expires = expiresAt
+	c.Value = id
+
+	removeCookieByName(w, c.Name)
+	http.SetCookie(w, c)
+}
+
+// remoteAddrIP returns the host part of the request's RemoteAddr.
+func remoteAddrIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func (k *KVStore) getOrInitKVSess(r *http.Request) *kvSession {
 	kvSess, ok := r.Context().Value(kvSessCtxKey{inst: k}).(*kvSession)
 	if ok {
@@ -148,6 +610,11 @@ type kvSessCtxKey struct{ inst *KVStore }
 // kvSession tracks information about the session across the request's context
 type kvSession struct {
 	id string
+	// data is the session data loaded or saved in this request.
+	data []byte
+	// version is the version of data, if the KV is versioned. It is 0 if
+	// there is no stored session.
+	version int64
 }
 
 func removeCookieByName(w http.ResponseWriter, cookieName string) {
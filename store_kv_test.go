@@ -0,0 +1,339 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKVStoreRevokeUser(t *testing.T) {
+	ctx := context.Background()
+
+	kvStore, err := NewKVStore(NewMemoryKV(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+		IdleTimeout: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotUser string
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			mgr.Reset(r.Context(), mgr.Get(r.Context()))
+			mgr.SetUserID(r.Context(), r.URL.Query().Get("user"))
+		case "/rotate":
+			mgr.Reset(r.Context(), mgr.Get(r.Context()))
+		}
+		gotUser = mgr.Metadata(r.Context()).UserID
+	}))
+
+	clients := map[string][]*http.Cookie{}
+	serve := func(client, path string) string {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, c := range clients[client] {
+			r.AddCookie(c)
+		}
+		h.ServeHTTP(rec, r)
+		if len(rec.Result().Cookies()) > 0 {
+			clients[client] = rec.Result().Cookies()
+		}
+		return gotUser
+	}
+
+	serve("a", "/login?user=user1")
+	serve("b", "/login?user=user1")
+	serve("c", "/login?user=user2")
+	// the index should follow the session when it is rotated
+	serve("a", "/rotate")
+
+	for client, want := range map[string]string{"a": "user1", "b": "user1", "c": "user2"} {
+		if got := serve(client, "/"); got != want {
+			t.Errorf("client %s: want user %q before revoke, got %q", client, want, got)
+		}
+	}
+
+	if err := kvStore.RevokeUser(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+
+	for client, want := range map[string]string{"a": "", "b": "", "c": "user2"} {
+		if got := serve(client, "/"); got != want {
+			t.Errorf("client %s: want user %q after revoke, got %q", client, want, got)
+		}
+	}
+
+	t.Run("Unsupported", func(t *testing.T) {
+		kvStore, err := NewKVStore(&countingKV{KV: NewMemoryKV()}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := kvStore.RevokeUser(ctx, "user1"); !errors.Is(err, ErrUserIndexUnsupported) {
+			t.Errorf("want ErrUserIndexUnsupported, got: %v", err)
+		}
+	})
+}
+
+func TestKVStoreUserSessions(t *testing.T) {
+	ctx := context.Background()
+
+	kvStore, err := NewKVStore(NewMemoryKV(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+		IdleTimeout: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var md Metadata
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			mgr.Reset(r.Context(), mgr.Get(r.Context()))
+			mgr.SetUserID(r.Context(), "user1")
+		}
+		md = mgr.Metadata(r.Context())
+	}))
+
+	clients := map[string][]*http.Cookie{}
+	serve := func(client, path string) Metadata {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("User-Agent", "agent-"+client)
+		r.RemoteAddr = "192.0.2.1:1234"
+		for _, c := range clients[client] {
+			r.AddCookie(c)
+		}
+		h.ServeHTTP(rec, r)
+		if len(rec.Result().Cookies()) > 0 {
+			clients[client] = rec.Result().Cookies()
+		}
+		return md
+	}
+
+	serve("a", "/login")
+	serve("b", "/login")
+	aID := serve("a", "/").ID
+	bID := serve("b", "/").ID
+
+	sessions, err := kvStore.ListUserSessions(ctx, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("want 2 sessions, got %d", len(sessions))
+	}
+	if sessions[0].ID != aID || sessions[1].ID != bID {
+		t.Errorf("want sessions [%s %s] oldest first, got [%s %s]", aID, bID, sessions[0].ID, sessions[1].ID)
+	}
+	for i, want := range []DeviceInfo{
+		{UserAgent: "agent-a", IP: "192.0.2.1"},
+		{UserAgent: "agent-b", IP: "192.0.2.1"},
+	} {
+		if sessions[i].Device != want {
+			t.Errorf("session %d: want device %v, got %v", i, want, sessions[i].Device)
+		}
+		if sessions[i].CreatedAt.IsZero() || sessions[i].LastSeenAt.Before(sessions[i].CreatedAt) {
+			t.Errorf("session %d: want created and last seen set, got %v", i, sessions[i])
+		}
+	}
+
+	if err := kvStore.RevokeSession(ctx, "user2", aID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("want ErrSessionNotFound revoking another user's session, got: %v", err)
+	}
+	if err := kvStore.RevokeSession(ctx, "user1", aID); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := serve("a", "/").UserID; got != "" {
+		t.Errorf("want revoked session to have no user, got %q", got)
+	}
+	if got := serve("b", "/").UserID; got != "user1" {
+		t.Errorf("want other session to remain, got user %q", got)
+	}
+
+	sessions, err = kvStore.ListUserSessions(ctx, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != bID {
+		t.Errorf("want only session %s listed, got %v", bID, sessions)
+	}
+}
+
+func TestKVStoreMaxSessionsPerUser(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		eviction    EvictionPolicy
+		wantUsers   map[string]string
+		wantRefused bool
+	}{
+		{
+			name:      "Oldest",
+			eviction:  EvictOldest,
+			wantUsers: map[string]string{"a": "", "b": "user1", "c": "user1"},
+		},
+		{
+			name:      "Least recently used",
+			eviction:  EvictLeastRecentlyUsed,
+			wantUsers: map[string]string{"a": "user1", "b": "", "c": "user1"},
+		},
+		{
+			name:        "Refuse",
+			eviction:    EvictRefuse,
+			wantUsers:   map[string]string{"a": "user1", "b": "user1", "c": ""},
+			wantRefused: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			kvStore, err := NewKVStore(NewMemoryKV(), &KVStoreOpts{
+				MaxSessionsPerUser: 2,
+				Eviction:           tc.eviction,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotErr error
+			mgr, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+				IdleTimeout: time.Hour,
+				ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+					gotErr = err
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotUser string
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/login" {
+					mgr.Reset(r.Context(), mgr.Get(r.Context()))
+					mgr.SetUserID(r.Context(), "user1")
+				}
+				gotUser = mgr.Metadata(r.Context()).UserID
+			}))
+
+			clients := map[string][]*http.Cookie{}
+			serve := func(client, path string) string {
+				rec := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, path, nil)
+				for _, c := range clients[client] {
+					r.AddCookie(c)
+				}
+				h.ServeHTTP(rec, r)
+				if len(rec.Result().Cookies()) > 0 {
+					clients[client] = rec.Result().Cookies()
+				}
+				return gotUser
+			}
+
+			serve("a", "/login")
+			serve("b", "/login")
+			// use a, so b is the least recently used
+			serve("a", "/")
+			serve("c", "/login")
+
+			if tc.wantRefused != errors.Is(gotErr, ErrTooManySessions) {
+				t.Errorf("want refused %t, got error: %v", tc.wantRefused, gotErr)
+			}
+			for client, want := range tc.wantUsers {
+				if got := serve(client, "/"); got != want {
+					t.Errorf("client %s: want user %q, got %q", client, want, got)
+				}
+			}
+		})
+	}
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := NewKVStore(&countingKV{KV: NewMemoryKV()}, &KVStoreOpts{MaxSessionsPerUser: 1})
+		if !errors.Is(err, ErrUserIndexUnsupported) {
+			t.Errorf("want ErrUserIndexUnsupported, got: %v", err)
+		}
+	})
+}
+
+// failingDeleteKV is a KV whose deletes fail.
+type failingDeleteKV struct {
+	KV
+}
+
+func (failingDeleteKV) Delete(context.Context, string) error {
+	return errors.New("delete failed")
+}
+
+func TestKVStoreDeleteSessionError(t *testing.T) {
+	kvStore, err := NewKVStore(failingDeleteKV{KV: NewMemoryKV()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const sid = "secret-session-id"
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: DefaultKVStoreCookieOpts.Name, Value: sid})
+	if _, err := kvStore.GetSession(r); err != nil {
+		t.Fatal(err)
+	}
+	// the unknown ID is replaced, so restore it to delete it.
+	kvStore.getOrInitKVSess(r).id = sid
+
+	err = kvStore.DeleteSession(httptest.NewRecorder(), r)
+	if err == nil {
+		t.Fatal("want error from failed delete")
+	}
+	if strings.Contains(err.Error(), sid) {
+		t.Errorf("want error not to contain the session ID, got: %v", err)
+	}
+}
+
+// failingGetKV is a KV whose reads fail.
+type failingGetKV struct {
+	KV
+}
+
+func (failingGetKV) Get(context.Context, string) ([]byte, bool, error) {
+	return nil, false, errors.New("get failed")
+}
+
+func TestKVStoreGetSessionErrorNewID(t *testing.T) {
+	kvStore, err := NewKVStore(failingGetKV{KV: NewMemoryKV()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := NewManager[jsonTestSession](kvStore, &ManagerOpts[*jsonTestSession]{
+		IdleTimeout: time.Hour,
+		// continue the request with a new session
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mgr.Save(r.Context(), mgr.Get(r.Context()))
+	}))
+
+	const attackerID = "ATTACKER"
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: DefaultKVStoreCookieOpts.Name, Value: attackerID})
+	h.ServeHTTP(rec, r)
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value == "" {
+		t.Fatalf("want new session cookie, got: %v", cookies)
+	}
+	if cookies[0].Value == attackerID {
+		t.Error("want session saved under a new ID, got the ID from the request")
+	}
+}
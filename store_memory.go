@@ -1,55 +1,479 @@
 package session
 
 import (
+	"container/list"
 	"context"
+	"fmt"
+	"hash/maphash"
+	"slices"
 	"sync"
 	"time"
 )
 
+// DefaultMemoryKVShards is the number of shards used by a MemoryKV if none is
+// configured.
+const DefaultMemoryKVShards = 16
+
+var (
+	_ KV          = (*MemoryKV)(nil)
+	_ Toucher     = (*MemoryKV)(nil)
+	_ UserIndexer = (*MemoryKV)(nil)
+	_ VersionedKV = (*MemoryKV)(nil)
+	_ Locker      = (*MemoryKV)(nil)
+)
+
+// MemoryKV is a KV that stores items in memory. Keys are spread across shards
+// that are locked independently, to reduce contention. Expired items are
+// removed when they are accessed or evicted, and optionally by a background
+// janitor. It is suitable for single-instance deployments, tests and
+// development, as items are not shared between instances and are lost on
+// restart.
+type MemoryKV struct {
+	shards []*memoryShard
+	seed   maphash.Seed
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type MemoryKVOpts struct {
+	// Shards is the number of shards the keys are spread across. Defaults to
+	// DefaultMemoryKVShards.
+	Shards int
+	// JanitorInterval is how often expired items are removed in the
+	// background. If set, Close must be called when the MemoryKV is no longer
+	// needed to stop the janitor. If 0, there is no janitor, and expired items
+	// are only removed when they are accessed or evicted.
+	JanitorInterval time.Duration
+	// MaxEntries is the maximum number of items stored. When it is exceeded,
+	// the least recently used items are evicted. The limit is divided
+	// between the shards, and enforced for each of them, so eviction is
+	// approximately least recently used across the whole KV. If 0, the
+	// number of items is unlimited.
+	MaxEntries int
+	// MaxBytes is the maximum total size of the stored keys and data, with
+	// eviction handled the same as MaxEntries. An item larger than its
+	// shard's share is stored, evicting all others in the shard. If 0, the
+	// size is unlimited.
+	MaxBytes int64
+}
+
+// MemoryKVStats are the statistics for a MemoryKV, returned by Stats.
+type MemoryKVStats struct {
+	// Entries is the number of items currently stored, including expired
+	// items that have not yet been removed.
+	Entries int
+	// Bytes is the total size of the stored keys and data.
+	Bytes int64
+	// Hits is the number of reads that found an unexpired item.
+	Hits uint64
+	// Misses is the number of reads that did not find an unexpired item.
+	Misses uint64
+	// Evictions is the number of items removed to stay within MaxEntries or
+	// MaxBytes.
+	Evictions uint64
+	// Expirations is the number of expired items removed.
+	Expirations uint64
+}
+
+type memoryShard struct {
+	mu    sync.Mutex
+	items map[string]*list.Element
+	// lru holds the *kvItems, most recently used first.
+	lru *list.List
+	// users indexes the keys in items by user ID.
+	users map[string]map[string]struct{}
+
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+
+	hits, misses, evictions, expirations uint64
+
+	locks   map[string]*keyLock
+	locksMu sync.Mutex
+}
+
 type kvItem struct {
-	data      []byte
-	expiresAt time.Time
+	key        string
+	data       []byte
+	expiresAt  time.Time
+	createdAt  time.Time
+	lastSeenAt time.Time
+	version    int64
+	userID     string
+	device     DeviceInfo
+}
+
+// size is the number of bytes the item counts towards MaxBytes.
+func (i *kvItem) size() int64 {
+	return int64(len(i.key) + len(i.data))
 }
 
-type memoryKV struct {
-	contents   map[string]kvItem
-	contentsMu sync.RWMutex
+// keyLock is a lock for a single key. ch holds a value while the lock is held,
+// refs counts the holders and waiters so it can be removed when unused.
+type keyLock struct {
+	ch   chan struct{}
+	refs int
 }
 
+// NewMemoryKV creates a MemoryKV with the default options, and no janitor.
 func NewMemoryKV() KV {
-	return &memoryKV{contents: make(map[string]kvItem)}
+	m, _ := NewMemoryKVWithOpts(nil)
+	return m
 }
 
-func (m *memoryKV) Get(_ context.Context, key string) (_ []byte, found bool, _ error) {
-	m.contentsMu.RLock()
-	defer m.contentsMu.RUnlock()
+// NewMemoryKVWithOpts creates a MemoryKV configured by opts.
+func NewMemoryKVWithOpts(opts *MemoryKVOpts) (*MemoryKV, error) {
+	if opts == nil {
+		opts = &MemoryKVOpts{}
+	}
+	switch {
+	case opts.Shards < 0:
+		return nil, fmt.Errorf("shards must not be negative, got %d", opts.Shards)
+	case opts.JanitorInterval < 0:
+		return nil, fmt.Errorf("janitor interval must not be negative, got %s", opts.JanitorInterval)
+	case opts.MaxEntries < 0:
+		return nil, fmt.Errorf("max entries must not be negative, got %d", opts.MaxEntries)
+	case opts.MaxBytes < 0:
+		return nil, fmt.Errorf("max bytes must not be negative, got %d", opts.MaxBytes)
+	}
 
-	v, ok := m.contents[key]
-	if !ok {
-		return nil, false, nil
+	n := opts.Shards
+	if n == 0 {
+		n = DefaultMemoryKVShards
 	}
-	if time.Now().After(v.expiresAt) {
-		delete(m.contents, key)
-		return nil, false, nil
+	m := &MemoryKV{
+		shards: make([]*memoryShard, n),
+		seed:   maphash.MakeSeed(),
 	}
-	return v.data, true, nil
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{
+			items:      make(map[string]*list.Element),
+			lru:        list.New(),
+			users:      make(map[string]map[string]struct{}),
+			maxEntries: divCeil(opts.MaxEntries, n),
+			maxBytes:   divCeil(opts.MaxBytes, int64(n)),
+			locks:      make(map[string]*keyLock),
+		}
+	}
+
+	if opts.JanitorInterval > 0 {
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		go m.runJanitor(opts.JanitorInterval)
+	}
+
+	return m, nil
 }
 
-func (m *memoryKV) Set(_ context.Context, key string, expiresAt time.Time, value []byte) error {
-	m.contentsMu.Lock()
-	defer m.contentsMu.Unlock()
+// divCeil divides the cap between the shards, rounding up so each shard can
+// hold at least one item.
+func divCeil[T int | int64](a, b T) T {
+	return (a + b - 1) / b
+}
 
-	m.contents[key] = kvItem{
-		data:      value,
-		expiresAt: expiresAt,
+// Close stops the janitor, if there is one. The MemoryKV can still be used,
+// but expired items are only removed when they are accessed or evicted.
+func (m *MemoryKV) Close() error {
+	if m.stop == nil {
+		return nil
 	}
+	m.closeOnce.Do(func() {
+		close(m.stop)
+		<-m.done
+	})
 	return nil
 }
 
-func (m *memoryKV) Delete(_ context.Context, key string) error {
-	m.contentsMu.Lock()
-	defer m.contentsMu.Unlock()
+func (m *MemoryKV) runJanitor(interval time.Duration) {
+	defer close(m.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	delete(m.contents, key)
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.removeExpired()
+		}
+	}
+}
+
+// removeExpired removes all the expired items, one shard at a time.
+func (m *MemoryKV) removeExpired() {
+	for _, s := range m.shards {
+		s.mu.Lock()
+		now := time.Now()
+		for _, e := range s.items {
+			if now.After(e.Value.(*kvItem).expiresAt) {
+				s.remove(e)
+				s.expirations++
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Stats returns the current statistics, summed across the shards.
+func (m *MemoryKV) Stats() MemoryKVStats {
+	var st MemoryKVStats
+	for _, s := range m.shards {
+		s.mu.Lock()
+		st.Entries += s.lru.Len()
+		st.Bytes += s.bytes
+		st.Hits += s.hits
+		st.Misses += s.misses
+		st.Evictions += s.evictions
+		st.Expirations += s.expirations
+		s.mu.Unlock()
+	}
+	return st
+}
+
+func (m *MemoryKV) shard(key string) *memoryShard {
+	return m.shards[maphash.String(m.seed, key)%uint64(len(m.shards))]
+}
+
+func (m *MemoryKV) Get(ctx context.Context, key string) (_ []byte, found bool, _ error) {
+	b, _, found, err := m.GetVersioned(ctx, key)
+	return b, found, err
+}
+
+func (m *MemoryKV) GetVersioned(_ context.Context, key string) (_ []byte, version int64, found bool, _ error) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := s.get(key)
+	if item == nil {
+		s.misses++
+		return nil, 0, false, nil
+	}
+	s.hits++
+	s.lru.MoveToFront(s.items[key])
+	return item.data, item.version, true, nil
+}
+
+func (m *MemoryKV) Set(_ context.Context, key string, expiresAt time.Time, value []byte) error {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.set(key, s.get(key), expiresAt, value)
+	return nil
+}
+
+func (m *MemoryKV) CompareAndSet(_ context.Context, key string, version int64, expiresAt time.Time, value []byte) error {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := s.get(key)
+	var current int64
+	if item != nil {
+		current = item.version
+	}
+	if current != version {
+		return ErrConflict
+	}
+	s.set(key, item, expiresAt, value)
 	return nil
 }
+
+func (m *MemoryKV) Touch(_ context.Context, key string, expiresAt time.Time) error {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := s.get(key)
+	if item == nil {
+		return nil
+	}
+	item.expiresAt = expiresAt
+	item.lastSeenAt = time.Now()
+	s.lru.MoveToFront(s.items[key])
+	return nil
+}
+
+func (m *MemoryKV) Delete(_ context.Context, key string) error {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.items[key]; ok {
+		s.remove(e)
+	}
+	return nil
+}
+
+func (m *MemoryKV) SetUser(_ context.Context, key, userID string, device DeviceInfo) error {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := s.get(key)
+	if item == nil {
+		return nil
+	}
+	s.unindex(key, item.userID)
+	item.userID = userID
+	item.device = device
+	if userID != "" {
+		if s.users[userID] == nil {
+			s.users[userID] = make(map[string]struct{})
+		}
+		s.users[userID][key] = struct{}{}
+	}
+	return nil
+}
+
+func (m *MemoryKV) ListUser(_ context.Context, userID string) ([]UserSession, error) {
+	var sessions []UserSession
+	for _, s := range m.shards {
+		s.mu.Lock()
+		now := time.Now()
+		for key := range s.users[userID] {
+			item := s.items[key].Value.(*kvItem)
+			if now.After(item.expiresAt) {
+				continue
+			}
+			sessions = append(sessions, UserSession{
+				ID:         key,
+				CreatedAt:  item.createdAt,
+				LastSeenAt: item.lastSeenAt,
+				Device:     item.device,
+			})
+		}
+		s.mu.Unlock()
+	}
+	slices.SortFunc(sessions, func(a, b UserSession) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (m *MemoryKV) DeleteUser(_ context.Context, userID string) (deleted int, _ error) {
+	for _, s := range m.shards {
+		s.mu.Lock()
+		now := time.Now()
+		for key := range s.users[userID] {
+			e := s.items[key]
+			if !now.After(e.Value.(*kvItem).expiresAt) {
+				deleted++
+			}
+			s.remove(e)
+		}
+		s.mu.Unlock()
+	}
+	return deleted, nil
+}
+
+func (m *MemoryKV) Lock(ctx context.Context, key string) (unlock func(), _ error) {
+	s := m.shard(key)
+
+	s.locksMu.Lock()
+	l, ok := s.locks[key]
+	if !ok {
+		l = &keyLock{ch: make(chan struct{}, 1)}
+		s.locks[key] = l
+	}
+	l.refs++
+	s.locksMu.Unlock()
+
+	select {
+	case l.ch <- struct{}{}:
+	case <-ctx.Done():
+		s.releaseLock(key, l)
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-l.ch
+			s.releaseLock(key, l)
+		})
+	}, nil
+}
+
+// releaseLock drops a reference to the lock for key, removing it if it is no
+// longer used.
+func (s *memoryShard) releaseLock(key string, l *keyLock) {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+
+	l.refs--
+	if l.refs == 0 {
+		delete(s.locks, key)
+	}
+}
+
+// get returns the unexpired item stored at key, or nil if there is none.
+// Expired items are removed. mu must be held.
+func (s *memoryShard) get(key string) *kvItem {
+	e, ok := s.items[key]
+	if !ok {
+		return nil
+	}
+	item := e.Value.(*kvItem)
+	if time.Now().After(item.expiresAt) {
+		s.remove(e)
+		s.expirations++
+		return nil
+	}
+	return item
+}
+
+// set writes value to item, retaining its user association, or stores a new
+// item at key if it is nil. Items are then evicted if the shard is over its
+// limits. mu must be held.
+func (s *memoryShard) set(key string, item *kvItem, expiresAt time.Time, value []byte) {
+	now := time.Now()
+	if item == nil {
+		item = &kvItem{key: key, createdAt: now}
+		s.items[key] = s.lru.PushFront(item)
+	} else {
+		s.bytes -= item.size()
+		s.lru.MoveToFront(s.items[key])
+	}
+	item.data = value
+	item.expiresAt = expiresAt
+	item.lastSeenAt = now
+	item.version++
+	s.bytes += item.size()
+
+	// the item just set is at the front, so is never evicted.
+	for s.lru.Len() > 1 && s.overLimit() {
+		s.remove(s.lru.Back())
+		s.evictions++
+	}
+}
+
+func (s *memoryShard) overLimit() bool {
+	return (s.maxEntries > 0 && s.lru.Len() > s.maxEntries) ||
+		(s.maxBytes > 0 && s.bytes > s.maxBytes)
+}
+
+// remove deletes the item in e from the shard and the user index. mu must be
+// held.
+func (s *memoryShard) remove(e *list.Element) {
+	item := e.Value.(*kvItem)
+	s.lru.Remove(e)
+	delete(s.items, item.key)
+	s.bytes -= item.size()
+	s.unindex(item.key, item.userID)
+}
+
+// unindex removes key from the user index. mu must be held.
+func (s *memoryShard) unindex(key, userID string) {
+	if userID == "" {
+		return
+	}
+	delete(s.users[userID], key)
+	if len(s.users[userID]) == 0 {
+		delete(s.users, userID)
+	}
+}
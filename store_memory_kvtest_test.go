@@ -0,0 +1,14 @@
+package session_test
+
+import (
+	"testing"
+
+	"github.com/lstoll/session"
+	"github.com/lstoll/session/kvtest"
+)
+
+// the conformance checks import session, so they run from the external test
+// package.
+func TestMemoryKVConformance(t *testing.T) {
+	kvtest.TestKV(t, func(t *testing.T) session.KV { return session.NewMemoryKV() })
+}
@@ -0,0 +1,199 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryKV(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Expiry", func(t *testing.T) {
+		kv, err := NewMemoryKVWithOpts(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := kv.Set(ctx, "expired", time.Now().Add(-time.Minute), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		if err := kv.Set(ctx, "valid", time.Now().Add(time.Hour), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok, _ := kv.Get(ctx, "expired"); ok {
+			t.Error("want expired key not found")
+		}
+		if _, ok, _ := kv.Get(ctx, "valid"); !ok {
+			t.Error("want valid key found")
+		}
+
+		want := MemoryKVStats{Entries: 1, Bytes: int64(len("valid") + len("value")), Hits: 1, Misses: 1, Expirations: 1}
+		if got := kv.Stats(); got != want {
+			t.Errorf("want stats %+v, got %+v", want, got)
+		}
+	})
+
+	t.Run("Janitor", func(t *testing.T) {
+		kv, err := NewMemoryKVWithOpts(&MemoryKVOpts{JanitorInterval: time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = kv.Close() })
+
+		if err := kv.Set(ctx, "key1", time.Now().Add(-time.Minute), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for kv.Stats().Entries != 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("janitor did not remove the expired item")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if got := kv.Stats().Expirations; got != 1 {
+			t.Errorf("want 1 expiration, got %d", got)
+		}
+
+		if err := kv.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := kv.Close(); err != nil {
+			t.Errorf("want closing twice to succeed, got: %v", err)
+		}
+
+		if kv := NewMemoryKV().(*MemoryKV); kv.stop != nil {
+			t.Error("want no janitor by default")
+		}
+	})
+
+	for _, tc := range []struct {
+		name string
+		opts *MemoryKVOpts
+		// keys are set in order, with "a" read after "b" is set so "b" is
+		// least recently used.
+		keys        []string
+		wantEvicted []string
+	}{
+		{
+			name:        "MaxEntries",
+			opts:        &MemoryKVOpts{Shards: 1, MaxEntries: 2},
+			keys:        []string{"a", "b", "c"},
+			wantEvicted: []string{"b"},
+		},
+		{
+			name:        "MaxBytes",
+			opts:        &MemoryKVOpts{Shards: 1, MaxBytes: 12},
+			keys:        []string{"a", "b", "c"},
+			wantEvicted: []string{"b"},
+		},
+		{
+			name:        "Unlimited",
+			opts:        &MemoryKVOpts{Shards: 1},
+			keys:        []string{"a", "b", "c"},
+			wantEvicted: nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			kv, err := NewMemoryKVWithOpts(tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, key := range tc.keys {
+				// each item is 6 bytes
+				if err := kv.Set(ctx, key, time.Now().Add(time.Hour), []byte("value")); err != nil {
+					t.Fatal(err)
+				}
+				if i == 1 {
+					if _, ok, _ := kv.Get(ctx, "a"); !ok {
+						t.Fatal("want a found")
+					}
+				}
+			}
+
+			evicted := map[string]bool{}
+			for _, key := range tc.wantEvicted {
+				evicted[key] = true
+			}
+			for _, key := range tc.keys {
+				if _, ok, _ := kv.Get(ctx, key); ok == evicted[key] {
+					t.Errorf("%s: want evicted %t, got found %t", key, evicted[key], ok)
+				}
+			}
+			if got := kv.Stats().Evictions; got != uint64(len(tc.wantEvicted)) {
+				t.Errorf("want %d evictions, got %d", len(tc.wantEvicted), got)
+			}
+		})
+	}
+
+	t.Run("EvictionUnindexesUser", func(t *testing.T) {
+		kv, err := NewMemoryKVWithOpts(&MemoryKVOpts{Shards: 1, MaxEntries: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := kv.Set(ctx, "a", time.Now().Add(time.Hour), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		if err := kv.SetUser(ctx, "a", "user1", DeviceInfo{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := kv.Set(ctx, "b", time.Now().Add(time.Hour), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+
+		sessions, err := kv.ListUser(ctx, "user1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 0 {
+			t.Errorf("want evicted session not listed, got %v", sessions)
+		}
+	})
+
+	t.Run("InvalidOpts", func(t *testing.T) {
+		for _, opts := range []*MemoryKVOpts{
+			{Shards: -1},
+			{JanitorInterval: -time.Second},
+			{MaxEntries: -1},
+			{MaxBytes: -1},
+		} {
+			if _, err := NewMemoryKVWithOpts(opts); err == nil {
+				t.Errorf("want error for opts %+v", opts)
+			}
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		kv, err := NewMemoryKVWithOpts(&MemoryKVOpts{JanitorInterval: time.Millisecond, MaxEntries: 50})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = kv.Close() })
+
+		var wg sync.WaitGroup
+		for i := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range 200 {
+					key := fmt.Sprintf("key%d", j%20)
+					// alternate between expired and valid items, so reads
+					// and the janitor remove them concurrently.
+					expiresAt := time.Now().Add(time.Duration(1-(i+j)%2) * time.Hour)
+					_ = kv.Set(ctx, key, expiresAt, []byte("value"))
+					_, _, _ = kv.Get(ctx, key)
+					_ = kv.Touch(ctx, key, time.Now().Add(time.Hour))
+					_ = kv.SetUser(ctx, key, "user1", DeviceInfo{})
+					_, _ = kv.ListUser(ctx, "user1")
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
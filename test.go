@@ -2,7 +2,6 @@ package session
 
 import (
 	"context"
-	"time"
 )
 
 type TestResult[T any] struct {
@@ -29,9 +28,7 @@ func (t *TestResult[T]) Result() T {
 // returned TestResult can be used to verify the actions against the session
 func TestContext[T any](mgr *Manager[T], ctx context.Context, sess T) (context.Context, *TestResult[T]) {
 	return context.WithValue(ctx, mgrSessCtxKey[T]{inst: mgr}, &sessCtx[T]{
-		metadata: &sessionMetadata{
-			CreatedAt: time.Now(),
-		},
-		data: sess,
+		metadata: newSessionMetadata(),
+		data:     sess,
 	}), nil
 }